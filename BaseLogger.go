@@ -5,6 +5,7 @@ package logger
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -37,6 +38,11 @@ type baseLogger struct {
 	submitQueue     chan strings.Builder
 	wg              sync.WaitGroup
 	stop            chan bool
+	stopped         chan struct{}
+	flushRequests   chan chan int64
+	bundlesQueued   int64
+	bundlesSent     int64
+	bundlesSentCond *sync.Cond
 }
 
 // BaseLogger constructor
@@ -85,6 +91,9 @@ func newBaseLogger(_agent string, _url string, _enabled interface{}, _queue []st
 		msgQueue:        make(chan string, config["MESSAGE_QUEUE_SIZE"]),
 		submitQueue:     make(chan strings.Builder, config["BUNDLE_QUEUE_SIZE"]),
 		stop:            make(chan bool, 1),
+		stopped:         make(chan struct{}),
+		flushRequests:   make(chan chan int64),
+		bundlesSentCond: sync.NewCond(&sync.Mutex{}),
 	}
 
 	constructedBaseLogger.wg.Add(1)
@@ -109,6 +118,11 @@ work:
 		if submission.Len() > 0 {
 			bundle := submission.String()
 			logger.submit(bundle)
+
+			logger.bundlesSentCond.L.Lock()
+			logger.bundlesSent++
+			logger.bundlesSentCond.Broadcast()
+			logger.bundlesSentCond.L.Unlock()
 		}
 		if !open {
 			break work
@@ -118,7 +132,14 @@ work:
 
 func (logger *baseLogger) dispatcher() {
 	defer logger.wg.Done()
+	defer close(logger.stopped)
 	buffer := strings.Builder{}
+	// bundles are counted so that flushes know when their bundle was submitted
+	enqueue := func() {
+		logger.bundlesQueued++
+		logger.submitQueue <- buffer
+		buffer = strings.Builder{}
+	}
 	autoFlush := time.NewTicker(time.Second)
 	logger.wg.Add(1)
 	go logger.worker()
@@ -131,10 +152,25 @@ dispatch:
 					buffer.WriteString(msg + "\n")
 				} else {
 					buffer.WriteString(msg)
-					logger.submitQueue <- buffer
-					buffer = strings.Builder{}
+					enqueue()
+				}
+			}
+		case reply := <-logger.flushRequests:
+		drain:
+			for {
+				select {
+				case msg := <-logger.msgQueue:
+					if msg != "" {
+						buffer.WriteString(msg + "\n")
+					}
+				default:
+					break drain
 				}
 			}
+			if buffer.Len() != 0 {
+				enqueue()
+			}
+			reply <- logger.bundlesQueued
 		case flush := <-logger.stop:
 			if flush {
 				select {
@@ -149,14 +185,13 @@ dispatch:
 				}
 			}
 			if buffer.Len() != 0 {
-				logger.submitQueue <- buffer
+				enqueue()
 			}
 			close(logger.submitQueue)
 			break dispatch
 		case <-autoFlush.C:
 			if buffer.Len() != 0 {
-				logger.submitQueue <- buffer
-				buffer = strings.Builder{}
+				enqueue()
 			}
 		}
 	}
@@ -264,6 +299,54 @@ func (logger *baseLogger) submit(msg string) {
 
 }
 
+// Flush() synchronously submits every message logged so far, blocking until submission has been attempted.
+// This is intended for environments like AWS Lambda, where the background dispatcher may be frozen between invocations.
+func (logger *baseLogger) Flush() {
+	_ = logger.FlushContext(context.Background())
+}
+
+// FlushContext(ctx) is like Flush(), but stops waiting once ctx is done, returning the error of ctx in that case.
+// Messages not yet submitted by then are still submitted in the background.
+func (logger *baseLogger) FlushContext(ctx context.Context) error {
+	reply := make(chan int64, 1)
+	select {
+	case logger.flushRequests <- reply:
+	case <-logger.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	var target int64
+	select {
+	case target = <-reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// wakes up the wait below if ctx is done before every bundle has been sent
+	waited := make(chan struct{})
+	defer close(waited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			logger.bundlesSentCond.L.Lock()
+			logger.bundlesSentCond.Broadcast()
+			logger.bundlesSentCond.L.Unlock()
+		case <-waited:
+		}
+	}()
+
+	logger.bundlesSentCond.L.Lock()
+	defer logger.bundlesSentCond.L.Unlock()
+	for logger.bundlesSent < target {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.bundlesSentCond.Wait()
+	}
+	return nil
+}
+
 func (logger *baseLogger) stopDispatcher() {
 	logger.Disable()
 	logger.stop <- true
@@ -301,3 +384,13 @@ func (logger *baseLogger) Enabled() bool {
 func (logger *baseLogger) Queue() []string {
 	return logger.queue
 }
+
+// SubmitFailures() returns the number of messages or bundles that failed to be submitted.
+func (logger *baseLogger) SubmitFailures() int64 {
+	return atomic.LoadInt64(&logger.submitFailures)
+}
+
+// SubmitSuccesses() returns the number of messages added to the queue, or of bundles submitted to the url.
+func (logger *baseLogger) SubmitSuccesses() int64 {
+	return atomic.LoadInt64(&logger.submitSuccesses)
+}
@@ -3,8 +3,13 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -221,3 +226,49 @@ func TestUsesSkipOptions(t *testing.T) {
 	assert.False(t, logger.skipCompression)
 	assert.True(t, logger.skipSubmission)
 }
+
+func TestFlushesSynchronously(t *testing.T) {
+	helper := newTestHelper()
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&received, 1)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	logger := newBaseLogger(helper.mockAgent, server.URL, true, nil)
+	assert.True(t, logger.Enabled())
+	logger.ndjsonHandler("{}")
+	logger.ndjsonHandler("{}")
+	logger.Flush()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&received))
+	assert.Equal(t, int64(1), logger.submitSuccesses)
+
+	logger.Flush()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&received))
+
+	logger.stopDispatcher()
+	logger.Flush()
+}
+
+func TestFlushContextStopsWaiting(t *testing.T) {
+	helper := newTestHelper()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	logger := newBaseLogger(helper.mockAgent, server.URL, true, nil)
+	logger.ndjsonHandler("{}")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, logger.FlushContext(ctx))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&logger.submitSuccesses))
+
+	close(release)
+	assert.Nil(t, logger.FlushContext(context.Background()))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&logger.submitSuccesses))
+	logger.stopDispatcher()
+}
@@ -6,13 +6,17 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"time"
+	"strings"
 )
 
 // NetHttpClientLogger defines a struct used to log specifically from the client side of API interactions using the net/http package.
 type NetHttpClientLogger struct {
 	http.Client
 	HttpLogger *HttpLogger
+
+	//TraceTimings enables net/http/httptrace instrumentation, logging DNS, connect, TLS handshake,
+	//time-to-first-byte and body transfer durations as additional details.
+	TraceTimings bool
 }
 
 // NewNetHttpClientLoggerOptions() takes 1 argument of type logger.Options and returns 2 objects; a pointer to an instance of an NetHttpClientLogger struct and an error.
@@ -52,116 +56,40 @@ func (clientLogger *NetHttpClientLogger) CloseIdleConnections() {
 }
 
 // net.http.Client.Do wrapper with logging
+// The call is logged when it completes, with up to LIMIT bytes of the response body, which remains readable by the caller.
 func (clientLogger *NetHttpClientLogger) Do(req *http.Request) (resp *http.Response, err error) {
-	// start time for logging interval
-	logger := clientLogger.HttpLogger
-
-	now := time.Now()
-
-	// capture the response or error
-	resp, err = clientLogger.Client.Do(req)
-
-	interval := time.Since(now).Milliseconds()
-
-	if err != nil {
-		return resp, err
-	}
-
-	// send logging message
-	SendHttpMessage(logger, resp, resp.Request, now.Unix()*int64(time.Millisecond), interval, nil)
-
-	return resp, err
+	return logRoundTrip(clientLogger.HttpLogger, req, clientLogger.TraceTimings, true, clientLogger.Client.Do)
 }
 
 // net.http.Client.Get wrapper with logging
 func (clientLogger *NetHttpClientLogger) Get(url string) (resp *http.Response, err error) {
-	// start time for logging interval
-	logger := clientLogger.HttpLogger
-
-	now := time.Now()
-
-	// capture the response or error
-	// Devin 03/31/2021
-	// Something happens here with the .Get where err does return an error = "unsupported protocol scheme"
-	resp, err = clientLogger.Client.Get(url)
-
-	interval := time.Since(now).Milliseconds()
-
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return resp, err
+		return nil, err
 	}
-
-	// send logging message
-	SendHttpMessage(logger, resp, resp.Request, now.Unix()*int64(time.Millisecond), interval, nil)
-
-	return resp, err
+	return clientLogger.Do(req)
 }
 
 // net.http.Client.Head wrapper with logging
 func (clientLogger *NetHttpClientLogger) Head(url string) (resp *http.Response, err error) {
-	// start time for logging interval
-
-	logger := clientLogger.HttpLogger
-
-	now := time.Now()
-
-	// capture the response or error
-	resp, err = clientLogger.Client.Head(url)
-
-	interval := time.Since(now).Milliseconds()
-
+	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
-		return resp, err
+		return nil, err
 	}
-
-	// send logging message
-	SendHttpMessage(logger, resp, resp.Request, now.Unix()*int64(time.Millisecond), interval, nil)
-
-	return resp, err
+	return clientLogger.Do(req)
 }
 
 // net.http.Client.Post wrapper with logging
 func (clientLogger *NetHttpClientLogger) Post(url string, contentType string, body io.Reader) (resp *http.Response, err error) {
-	// start time for logging interval
-
-	logger := clientLogger.HttpLogger
-
-	now := time.Now()
-
-	// capture the response or error
-	resp, err = clientLogger.Client.Post(url, contentType, body)
-
-	interval := time.Since(now).Milliseconds()
-
+	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
-		return resp, err
+		return nil, err
 	}
-
-	// send logging message
-	SendHttpMessage(logger, resp, resp.Request, now.Unix()*int64(time.Millisecond), interval, nil)
-
-	return resp, err
+	req.Header.Set("Content-Type", contentType)
+	return clientLogger.Do(req)
 }
 
 // net.http.Client.PostForm wrapper with logging
 func (clientLogger *NetHttpClientLogger) PostForm(url string, data url.Values) (resp *http.Response, err error) {
-	// start time for logging interval
-
-	logger := clientLogger.HttpLogger
-
-	now := time.Now()
-
-	// capture the response or error
-	resp, err = clientLogger.Client.PostForm(url, data)
-
-	interval := time.Since(now).Milliseconds()
-
-	if err != nil {
-		return resp, err
-	}
-
-	// send logging message
-	SendHttpMessage(logger, resp, resp.Request, now.Unix()*int64(time.Millisecond), interval, nil)
-
-	return resp, err
+	return clientLogger.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
 }
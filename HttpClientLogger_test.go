@@ -0,0 +1,222 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientLoggerLogsRefusedConnection(t *testing.T) {
+	server := newTestServer()
+	url := server.URL
+	server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	_, err := clientLogger.Get(url + "/refused")
+	assert.NotNil(t, err)
+
+	queue := clientLogger.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.True(t, parseable(queue[0]))
+	assert.Contains(t, queue[0], "[\"request_url\",\""+url+"/refused\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"502\"]")
+	assert.Contains(t, queue[0], "[\"response_error\",\"connection_refused: ")
+}
+
+func TestClientLoggerLogsTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	clientLogger.Timeout = 20 * time.Millisecond
+
+	_, err := clientLogger.Post(server.URL, "text/plain", strings.NewReader("slow"))
+	assert.NotNil(t, err)
+
+	queue := clientLogger.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"request_method\",\"POST\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"504\"]")
+	assert.Contains(t, queue[0], "[\"response_error\",\"timeout: ")
+}
+
+func TestClientLoggerAppliesRulesToErrors(t *testing.T) {
+	server := newTestServer()
+	url := server.URL
+	server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug\n/response_error/ stop",
+	})
+
+	_, err := clientLogger.Get(url)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(clientLogger.Logger().Queue()))
+}
+
+func TestClientLoggerKeepsUrlsOutOfErrors(t *testing.T) {
+	server := newTestServer()
+	url := server.URL
+	server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+	})
+
+	_, err := clientLogger.Get(url + "/refused?token=supersecret")
+	assert.Contains(t, err.Error(), "supersecret")
+
+	queue := clientLogger.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"request_url\",\""+url+"/refused\"]")
+	assert.Contains(t, queue[0], "[\"response_error\",\"connection_refused: Get: dial tcp ")
+	assert.NotContains(t, queue[0], "supersecret")
+}
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, "canceled", errorClass(context.Canceled))
+	assert.Equal(t, "timeout", errorClass(context.DeadlineExceeded))
+	assert.Equal(t, "error", errorClass(errors.New("boom")))
+	assert.Equal(t, 499, errorResponseCode("canceled"))
+	assert.Equal(t, 504, errorResponseCode("timeout"))
+	assert.Equal(t, 502, errorResponseCode("dns"))
+
+	urlError := &url.Error{Op: "Get", URL: "http://localhost/?token=secret", Err: errors.New("boom")}
+	assert.Equal(t, "Get: boom", errorText(urlError))
+	assert.Equal(t, "retrying: Get: boom", errorText(fmt.Errorf("retrying: %w", urlError)))
+	assert.Equal(t, "boom", errorText(errors.New("boom")))
+}
+
+func TestClientLoggerPreservesResponseBody(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	resp, err := clientLogger.Post(server.URL, "text/plain", strings.NewReader("hello"))
+	assert.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "echo: hello", string(body))
+
+	queue := clientLogger.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"request_body\",\"hello\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"echo: hello\"]")
+}
+
+func TestClientLoggerLogsWhenCallCompletes(t *testing.T) {
+	body := strings.Repeat("x", LIMIT+10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	// logged before the body is read or closed
+	resp, err := clientLogger.Get(server.URL)
+	assert.Nil(t, err)
+	queue := clientLogger.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"response_body\",\""+body[:LIMIT]+"\"]")
+
+	// whole body is still read by the caller
+	read, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Body.Close())
+	assert.Equal(t, body, string(read))
+	assert.Equal(t, 1, len(clientLogger.Logger().Queue()))
+}
+
+func TestClientLoggerSetsNowInMilliseconds(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	resp, err := clientLogger.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	after := time.Now().UnixNano() / int64(time.Millisecond)
+
+	var details [][]string
+	assert.Nil(t, json.Unmarshal([]byte(clientLogger.Logger().Queue()[0]), &details))
+	for _, d := range details {
+		if d[0] == "now" {
+			now, _ := strconv.ParseInt(d[1], 10, 64)
+			assert.GreaterOrEqual(t, now, before)
+			assert.LessOrEqual(t, now, after)
+		}
+	}
+}
+
+func TestClientLoggerTracesTimings(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	clientLogger, _ := NewNetHttpClientLoggerOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	resp, err := clientLogger.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.NotContains(t, clientLogger.Logger().Queue()[0], "interval_ttfb")
+
+	clientLogger.TraceTimings = true
+	clientLogger.CloseIdleConnections()
+	resp, err = clientLogger.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	queue := clientLogger.Logger().Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.Contains(t, queue[1], "[\"interval_connect\",\"")
+	assert.Contains(t, queue[1], "[\"interval_ttfb\",\"")
+	assert.Contains(t, queue[1], "[\"interval_transfer\",\"")
+	assert.NotContains(t, queue[1], "interval_tls")
+}
@@ -5,9 +5,12 @@ package logger
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,6 +28,59 @@ type Options struct {
 	//Queue is a slice of strings used to store logs; exclusively for testing purposes.
 	//Queue must be nil for the logger to properly function.
 	Queue []string
+
+	//Sessions defines how WebSocket sessions and Server-Sent Events streams are logged by HttpLoggerForMux.
+	Sessions SessionOptions
+
+	//PreserveCustomFieldValues logs custom field values verbatim, instead of in lowercase,
+	//for values like order IDs and tokens where case matters. It is always set for transport, proxy, gRPC and Lambda loggers.
+	PreserveCustomFieldValues bool
+
+	//RequestId defines how request IDs are read or generated by HttpLoggerForMux and HttpLoggerForProxy.
+	RequestId RequestIdOptions
+
+	//TrustedProxies lists the proxies, as CIDRs or single addresses, trusted to set the Forwarded, X-Forwarded-For
+	//and X-Real-IP headers used to find the IP address of clients. These headers are ignored when empty.
+	TrustedProxies []string
+
+	//HashKey is the secret key of the hash and tokenize rules, which is required when these are used.
+	//Defaults to the USAGE_LOGGERS_HASH_KEY env var.
+	HashKey string
+
+	//ConnectionDetails logs the HTTP protocol version of requests, and for requests over TLS, the TLS version,
+	//cipher suite, server name (SNI) and client certificate subject.
+	ConnectionDetails bool
+}
+
+// SessionOptions struct is used in Options to configure the logging of upgraded connections (such as WebSockets)
+// and Server-Sent Events streams.
+type SessionOptions struct {
+	//Enabled logs the handshake as a message as soon as it completes, instead of when the session ends.
+	Enabled bool
+
+	//Transcript records the frames or events exchanged after the handshake, and logs them as a second message
+	//once the session ends. Transcript has no effect unless Enabled is true.
+	Transcript bool
+
+	//MaxEvents limits the number of frames or events recorded per session. Defaults to 100.
+	MaxEvents int
+
+	//MaxPayload limits the number of payload bytes recorded per frame or event. Defaults to 1024.
+	MaxPayload int
+}
+
+// RequestIdOptions struct is used in Options to configure request IDs, which link each request handled by a middleware
+// to its message, its response and application logs.
+type RequestIdOptions struct {
+	//Enabled reads request IDs from incoming requests, or generates them when missing, and sets them on responses,
+	//request contexts and messages as a request_id detail.
+	Enabled bool
+
+	//Header is the name of the request and response header holding request IDs. Defaults to X-Request-Id.
+	Header string
+
+	//Format is the format of generated request IDs, either "uuid" (the default) or "ulid".
+	Format string
 }
 
 const httpLoggerAgent string = "HttpLogger.go"
@@ -32,9 +88,26 @@ const httpLoggerAgent string = "HttpLogger.go"
 // HttpLogger is the struct contains a pointer to a baseLogger instance and a set of rules used to define the behaviour of the logger.
 type HttpLogger struct {
 	*baseLogger
-	rules *HttpRules
+	rules                 *HttpRules
+	sessions              SessionOptions
+	lowercaseCustomFields bool
+	requestIds            RequestIdOptions
+	trustedProxies        []*net.IPNet
+	connectionDetails     bool
+	enrichers             []Enricher
+	submitListeners       []SubmitListener
+	enrichersMu           sync.RWMutex
 }
 
+// Enricher is a function registered with AddEnricher, that adds details to message computed from the logged request and
+// response before rules are applied. Either req or resp is nil when not available, such as for requests that failed
+// without a response, and messages submitted with SubmitHttpMessage.
+type Enricher func(message *HttpMessage, req *http.Request, resp *http.Response)
+
+// SubmitListener is a function registered with AddSubmitListener, that is called with every message submitted, as changed
+// by rules, along with the logged request and response, which are nil as they are for enrichers.
+type SubmitListener func(message *HttpMessage, req *http.Request, resp *http.Response)
+
 // NewHttpLogger returns a pointer to a new HttpLogger object, with the given options applied, and an error
 func NewHttpLogger(options Options) (*HttpLogger, error) {
 	baseLogger := newBaseLogger(httpLoggerAgent, options.Url, options.Enabled, options.Queue)
@@ -44,9 +117,45 @@ func NewHttpLogger(options Options) (*HttpLogger, error) {
 		return nil, err
 	}
 
+	if len(loggerRules.hash) > 0 || len(loggerRules.tokenize) > 0 {
+		hashKey := options.HashKey
+		if hashKey == "" {
+			usageLoggers, _ := GetUsageLoggers()
+			hashKey = usageLoggers.HashKeyByDefault()
+		}
+		if hashKey == "" {
+			return nil, fmt.Errorf("hash and tokenize rules require a key")
+		}
+		loggerRules.hashKey = []byte(hashKey)
+	}
+
+	requestIds, err := newRequestIdOptions(options.RequestId)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseTrustedProxies(options.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := options.Sessions
+	if sessions.MaxEvents <= 0 {
+		sessions.MaxEvents = 100
+	}
+	if sessions.MaxPayload <= 0 {
+		sessions.MaxPayload = 1024
+	}
+
 	logger := &HttpLogger{
-		baseLogger,
-		loggerRules,
+		baseLogger: baseLogger,
+		rules:      loggerRules,
+		sessions:   sessions,
+
+		lowercaseCustomFields: !options.PreserveCustomFieldValues,
+		requestIds:            requestIds,
+		trustedProxies:        trustedProxies,
+		connectionDetails:     options.ConnectionDetails,
 	}
 
 	logger.skipCompression = loggerRules.skipCompression
@@ -60,36 +169,97 @@ func NewHttpLogger(options Options) (*HttpLogger, error) {
 	return logger, nil
 }
 
-func (logger *HttpLogger) submitIfPassing(msg [][]string, customFields map[string]string) {
-	msg = logger.rules.apply(msg)
+// AddEnricher(e Enricher) registers enricher e, to be called for every message logged, in the order enrichers were added.
+func (logger *HttpLogger) AddEnricher(enricher Enricher) {
+	logger.enrichersMu.Lock()
+	defer logger.enrichersMu.Unlock()
+	logger.enrichers = append(logger.enrichers, enricher)
+}
+
+// AddSubmitListener(l SubmitListener) registers listener l, to be called for every message submitted, in the order
+// listeners were added.
+func (logger *HttpLogger) AddSubmitListener(listener SubmitListener) {
+	logger.enrichersMu.Lock()
+	defer logger.enrichersMu.Unlock()
+	logger.submitListeners = append(logger.submitListeners, listener)
+}
+
+// calls registered enrichers on message
+func (logger *HttpLogger) enrich(message *HttpMessage, req *http.Request, resp *http.Response) {
+	logger.enrichersMu.RLock()
+	enrichers := logger.enrichers
+	logger.enrichersMu.RUnlock()
+
+	for _, enricher := range enrichers {
+		enricher(message, req, resp)
+	}
+}
+
+// calls registered submit listeners on message
+func (logger *HttpLogger) notifySubmitted(message *HttpMessage, req *http.Request, resp *http.Response) {
+	logger.enrichersMu.RLock()
+	listeners := logger.submitListeners
+	logger.enrichersMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(message, req, resp)
+	}
+}
 
-	if msg == nil {
-		return
+// returns false if message was stopped by rules
+func (logger *HttpLogger) submitIfPassing(message *HttpMessage, customFields map[string]string) bool {
+	if !logger.rules.apply(message) {
+		return false
 	}
 
 	for key, val := range customFields {
-		msg = append(msg, []string{"custom_field:" + strings.ToLower(key), strings.ToLower(val)})
+		name, ok := customFieldName(key)
+		if !ok {
+			continue
+		}
+		if logger.lowercaseCustomFields {
+			val = strings.ToLower(val)
+		}
+		message.Add(name, val)
 	}
 
-	msg = append(msg, []string{"host", logger.host})
+	// pre-built messages may come from other hosts
+	if !message.Has("host") {
+		message.Add("host", logger.host)
+	}
 
-	byteStr, _ := json.Marshal(msg)
+	byteStr, _ := json.Marshal(message.details)
 
 	msgString := string(byteStr)
 	msgString = strings.Replace(msgString, "\\u003c", "<", -1)
 	msgString = strings.Replace(msgString, "\\u003e", ">", -1)
 	logger.ndjsonHandler(msgString)
+	return true
+}
+
+// Submit(d [][]string, customFields map[string]string) submits details d as a message, as with SubmitHttpMessage,
+// and returns an error if any detail isn't a pair of a name and a value.
+func (logger *HttpLogger) Submit(details [][]string, customFields map[string]string) error {
+	message, err := NewHttpMessageFromDetails(details)
+	if err != nil {
+		return err
+	}
+	SubmitHttpMessage(logger, message, customFields)
+	return nil
 }
 
 // global client to avoid opening a new connection for every request
 var httpLoggerClient *http.Client
 
+// time allowed for each submission, so a stalled connection can't hold up the dispatcher or Flush() forever
+const submitTimeout = 30 * time.Second
+
 func init() {
 	tr := &http.Transport{
 		MaxIdleConnsPerHost: 10000,
 		TLSHandshakeTimeout: 0 * time.Second,
 	}
-	httpLoggerClient = &http.Client{Transport: tr}
+	httpLoggerClient = &http.Client{Transport: tr, Timeout: submitTimeout}
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 }
@@ -3,10 +3,12 @@
 package logger
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -25,6 +27,12 @@ type (
 	loggingResponseWriter struct { //custom response writer to wrap original writer in
 		http.ResponseWriter
 		loggingResp *http.Response
+		body        bytes.Buffer
+		size        int
+		written     bool
+		wroteHeader bool
+		hijacked    bool
+		session     *httpSession
 	}
 )
 
@@ -71,28 +79,23 @@ func NewHttpLoggerForMuxOptions(options Options) (*HttpLoggerForMux, error) {
 // Write(b []byte) uses original response writer to write the body b to the client and then logs the response body.
 // This is only used internally by response writer.
 func (w *loggingResponseWriter) Write(body []byte) (int, error) { // uses original response writer to write and then logs the size
+	if !w.wroteHeader {
+		w.respond(w.loggingResp.StatusCode)
+	}
 
 	size, err := w.ResponseWriter.Write(body)
 
-	if err == nil {
-		defer func() {
-			w.loggingResp.Header = w.ResponseWriter.Header()
-			if size > 0 {
-				w.loggingResp.Header.Set("Content-Length", fmt.Sprint(size))
+	w.written = true
+	if size > 0 {
+		w.size += size
+		if remaining := LIMIT - w.body.Len(); remaining > 0 {
+			if remaining > size {
+				remaining = size
 			}
-		}()
-
-		var loggedBodyBytes []byte
-		if size < LIMIT {
-			loggedBodyBytes = body
-		} else {
-			loggedBodyBytes = []byte(fmt.Sprintf("{ overflowed: %d }", size))
+			w.body.Write(body[:remaining])
 		}
-
-		w.loggingResp = &http.Response{
-			Header:     w.loggingResp.Header,
-			Body:       io.NopCloser(bytes.NewBuffer(loggedBodyBytes)),
-			StatusCode: w.loggingResp.StatusCode, // Status Code 200 will only be overridden if writeHeader is called
+		if w.session.isStreaming() {
+			w.session.streamWritten(body[:size])
 		}
 	}
 
@@ -105,6 +108,69 @@ func (w *loggingResponseWriter) WriteHeader(statusCode int) {
 	w.loggingResp.StatusCode = statusCode
 
 	w.ResponseWriter.WriteHeader(statusCode)
+
+	// informational responses may be followed by others
+	if !w.wroteHeader && statusCode >= 200 {
+		w.respond(statusCode)
+	}
+}
+
+// Flush() sends any buffered data to the client, as needed by Server-Sent Events streams.
+// This is only used internally by response writer.
+func (w *loggingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.respond(w.loggingResp.StatusCode)
+		}
+		flusher.Flush()
+	}
+}
+
+// Hijack() lets the handler take over the connection, as needed to upgrade it to a WebSocket.
+// The returned connection records the session taking place over it.
+// This is only used internally by response writer.
+func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	loggingConn := &sessionConn{Conn: conn, session: w.session}
+	return loggingConn, newSessionReadWriter(loggingConn, rw), nil
+}
+
+// Unwrap() returns the original response writer, for use by http.ResponseController.
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// called once the response head is known
+func (w *loggingResponseWriter) respond(statusCode int) {
+	w.wroteHeader = true
+	w.session.respond(statusCode, w.ResponseWriter.Header())
+}
+
+// returns the response to log, with up to LIMIT bytes of its body
+func (w *loggingResponseWriter) response() *http.Response {
+	resp := &http.Response{
+		StatusCode: w.loggingResp.StatusCode, // Status Code 200 will only be overridden if writeHeader is called
+		Header:     w.ResponseWriter.Header().Clone(),
+	}
+	if w.written {
+		if w.size > 0 {
+			resp.Header.Set("Content-Length", fmt.Sprint(w.size))
+		}
+		if w.size < LIMIT {
+			resp.Body = io.NopCloser(bytes.NewReader(w.body.Bytes()))
+		} else {
+			resp.Body = io.NopCloser(bytes.NewBufferString(fmt.Sprintf("{ overflowed: %d }", w.size)))
+		}
+	}
+	return resp
 }
 
 // LogData() takes 1 argument of type http.Handler and returns an object of the same type, http.Handler.
@@ -113,20 +179,38 @@ func (w *loggingResponseWriter) WriteHeader(statusCode int) {
 func (muxLogger HttpLoggerForMux) LogData(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		loggingWriter := loggingResponseWriter{
+		loggingWriter := &loggingResponseWriter{
 			ResponseWriter: w,
 			loggingResp: &http.Response{
 				StatusCode: 200,
 			},
 		}
 
-		buf, err := io.ReadAll(r.Body)
-		if err != nil {
-			log.Fatal(err)
+		// multipart/form-data bodies, like file uploads, are summarized as the handler reads them instead of being buffered
+		var loggingBody io.ReadCloser
+		if multipartBoundary(r.Header) != "" && r.Body != nil {
+			capture := newRequestCaptureReadCloser(r.Body, r.Header)
+			r.Body = capture
+			loggingBody = capture.Logged()
+		} else {
+			buf, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Fatal(err)
+			}
+			r.Body.Close()
+
+			r.Body = io.NopCloser(bytes.NewBuffer(buf))
+			loggingBody = io.NopCloser(bytes.NewBuffer(buf))
 		}
-		r.Body.Close()
+		defer loggingBody.Close()
 
-		r.Body = io.NopCloser(bytes.NewBuffer(buf))
+		// lets handlers attach custom fields with SetCustomField(r.Context(), ...)
+		ctx := WithCustomFields(r.Context())
+		if requestId, requestCtx := muxLogger.HttpLogger.withRequestId(ctx, r); requestId != "" {
+			w.Header().Set(muxLogger.HttpLogger.requestIds.Header, requestId)
+			ctx = requestCtx
+		}
+		r = r.WithContext(ctx)
 
 		loggingReq := &http.Request{
 			Method:        r.Method,
@@ -145,15 +229,28 @@ func (muxLogger HttpLoggerForMux) LogData(next http.Handler) http.Handler {
 			TLS:           r.TLS,
 			MultipartForm: r.MultipartForm,
 			Response:      r.Response,
-			Body:          io.NopCloser(bytes.NewBuffer(buf)),
+			Body:          loggingBody,
 		}
+		loggingReq = loggingReq.WithContext(r.Context())
 
 		now := time.Now()
 
-		next.ServeHTTP(&loggingWriter, r)
+		loggingWriter.session = newHttpSession(muxLogger.HttpLogger, loggingReq, now)
+
+		next.ServeHTTP(loggingWriter, r)
 
 		interval := time.Since(now).Milliseconds()
 
-		SendHttpMessage(muxLogger.HttpLogger, loggingWriter.loggingResp, loggingReq, now.UnixNano()/int64(time.Millisecond), interval, nil)
+		// sessions are logged as they progress, rather than once the handler returns
+		if loggingWriter.hijacked {
+			loggingWriter.session.logHandshake()
+			return
+		}
+		if loggingWriter.session.isStreaming() {
+			loggingWriter.session.end()
+			return
+		}
+
+		SendHttpMessage(muxLogger.HttpLogger, loggingWriter.response(), loggingReq, now.UnixNano()/int64(time.Millisecond), interval, nil)
 	})
 }
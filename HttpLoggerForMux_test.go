@@ -0,0 +1,293 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writes a single unfragmented websocket frame, masked as required for clients
+func writeTestFrame(w io.Writer, opcode byte, payload string, masked bool) error {
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	data := []byte(payload)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame[1] |= 0x80
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+// reads a single unfragmented and unmasked websocket frame
+func readTestFrame(r io.Reader) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	payload := make([]byte, header[1]&0x7f)
+	_, err := io.ReadFull(r, payload)
+	return string(payload), err
+}
+
+// upgrades the connection and echoes text frames until the client closes
+func newTestWebSocketServer(logger *HttpLoggerForMux) *httptest.Server {
+	return httptest.NewServer(logger.LogData(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+		for {
+			header := make([]byte, 6)
+			if _, err := io.ReadFull(rw, header); err != nil {
+				return
+			}
+			payload := make([]byte, header[1]&0x7f)
+			if _, err := io.ReadFull(rw, payload); err != nil {
+				return
+			}
+			for i := range payload {
+				payload[i] ^= header[2+i%4]
+			}
+			if header[0]&0x0f == 0x8 {
+				_ = writeTestFrame(conn, 0x8, "", false)
+				return
+			}
+			_ = writeTestFrame(conn, 0x1, "echo: "+string(payload), false)
+		}
+	})))
+}
+
+func dialTestWebSocket(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	assert.Nil(t, err)
+	_, err = fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n", strings.TrimPrefix(url, "http://"))
+	assert.Nil(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, resp.StatusCode)
+	return conn, reader
+}
+
+func TestMuxLogsWebSocketTranscript(t *testing.T) {
+	muxLogger, err := NewHttpLoggerForMuxOptions(Options{
+		Queue:    make([]string, 0),
+		Enabled:  true,
+		Rules:    "include debug\n/request_body/ replace /secret/, /xxx/",
+		Sessions: SessionOptions{Enabled: true, Transcript: true},
+	})
+	assert.Nil(t, err)
+	server := newTestWebSocketServer(muxLogger)
+	defer server.Close()
+
+	conn, reader := dialTestWebSocket(t, server.URL)
+	defer conn.Close()
+	assert.Nil(t, writeTestFrame(conn, 0x1, "hello secret", true))
+	echo, err := readTestFrame(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo: hello secret", echo)
+	assert.Nil(t, writeTestFrame(conn, 0x8, "", true))
+	_, err = readTestFrame(reader)
+	assert.Nil(t, err)
+
+	// hijacked connections outlive the server, so wait for the handler to finish
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&muxLogger.HttpLogger.submitSuccesses) == 2 }, time.Second, 10*time.Millisecond)
+	queue := muxLogger.HttpLogger.Queue()
+	assert.True(t, parseable(queue[0]))
+	assert.Contains(t, queue[0], "[\"request_url\",\"http://"+strings.TrimPrefix(server.URL, "http://")+"/chat\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"101\"]")
+	assert.Contains(t, queue[0], "[\"response_header:upgrade\",\"websocket\"]")
+	assert.NotContains(t, queue[0], "session_events")
+
+	assert.True(t, parseable(queue[1]))
+	assert.Contains(t, queue[1], "[\"response_code\",\"101\"]")
+	assert.Contains(t, queue[1], "\\\"type\\\":\\\"text\\\",\\\"size\\\":12,\\\"payload\\\":\\\"hello xxx\\\"")
+	assert.Contains(t, queue[1], "\\\"type\\\":\\\"text\\\",\\\"size\\\":18,\\\"payload\\\":\\\"echo: hello secret\\\"")
+	assert.Contains(t, queue[1], "\\\"type\\\":\\\"close\\\",\\\"size\\\":0")
+	assert.Contains(t, queue[1], "[\"session_events\",\"4\"]")
+}
+
+func TestMuxLogsWebSocketHandshakeOnly(t *testing.T) {
+	muxLogger, _ := NewHttpLoggerForMuxOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	server := newTestWebSocketServer(muxLogger)
+	defer server.Close()
+
+	conn, reader := dialTestWebSocket(t, server.URL)
+	assert.Nil(t, writeTestFrame(conn, 0x1, "hello", true))
+	_, _ = readTestFrame(reader)
+	conn.Close()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&muxLogger.HttpLogger.submitSuccesses) == 1 }, time.Second, 10*time.Millisecond)
+	queue := muxLogger.HttpLogger.Queue()
+	assert.Contains(t, queue[0], "[\"response_code\",\"101\"]")
+	assert.NotContains(t, queue[0], "hello")
+}
+
+func TestMuxLogsEventStream(t *testing.T) {
+	muxLogger, _ := NewHttpLoggerForMuxOptions(Options{
+		Queue:    make([]string, 0),
+		Enabled:  true,
+		Rules:    "include debug",
+		Sessions: SessionOptions{Enabled: true, Transcript: true, MaxEvents: 2, MaxPayload: 12},
+	})
+	server := httptest.NewServer(muxLogger.LogData(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			_, _ = fmt.Fprintf(w, "id: %d\ndata: event number %d\n\n", i, i)
+			w.(http.Flusher).Flush()
+		}
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 3, strings.Count(string(body), "data: "))
+
+	queue := muxLogger.HttpLogger.Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.Contains(t, queue[0], "[\"response_code\",\"200\"]")
+	assert.Contains(t, queue[0], "[\"response_header:content-type\",\"text/event-stream\"]")
+	assert.NotContains(t, queue[0], "response_body")
+	assert.Contains(t, queue[1], "\\\"type\\\":\\\"event\\\",\\\"size\\\":26,\\\"payload\\\":\\\"id: 1\\\\ndata: \\\"}")
+	assert.NotContains(t, queue[1], "id: 3")
+	assert.Contains(t, queue[1], "[\"session_events\",\"3\"]")
+}
+
+func TestMuxLeavesSessionRequestsUnchanged(t *testing.T) {
+	muxLogger, _ := NewHttpLoggerForMuxOptions(Options{
+		Queue:    make([]string, 0),
+		Enabled:  true,
+		Rules:    "include debug",
+		Sessions: SessionOptions{Enabled: true, Transcript: true},
+	})
+	var rawQuery string
+	var form url.Values
+	server := httptest.NewServer(muxLogger.LogData(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: one\n\n")
+		w.(http.Flusher).Flush()
+		// the handshake has been logged by now
+		rawQuery, form = r.URL.RawQuery, r.Form
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?a=1;b=2")
+	assert.Nil(t, err)
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, "a=1;b=2", rawQuery)
+	assert.Nil(t, form)
+	queue := muxLogger.HttpLogger.Queue()
+	assert.Equal(t, 2, len(queue))
+	for _, msg := range queue {
+		assert.Contains(t, msg, "[\"request_url\",\""+server.URL+"/events?a=1;b=2\"]")
+		assert.Contains(t, msg, "[\"request_param:a\",\"1;b=2\"]")
+	}
+	assert.NotContains(t, queue[1], "request_body")
+}
+
+func TestMuxLogsEveryWrite(t *testing.T) {
+	muxLogger, _ := NewHttpLoggerForMuxOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	server := httptest.NewServer(muxLogger.LogData(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: one\n\n"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("data: two\n\n"))
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	queue := muxLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"response_body\",\"data: one\\n\\ndata: two\\n\\n\"]")
+	assert.Contains(t, queue[0], "[\"response_header:content-length\",\"22\"]")
+}
+
+func TestMuxLogsCustomFieldsFromHandlers(t *testing.T) {
+	muxLogger, _ := NewHttpLoggerForMuxOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	server := httptest.NewServer(muxLogger.LogData(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetCustomField(r.Context(), "tenant", "acme")
+		_, _ = w.Write([]byte("ok"))
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	queue := muxLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"custom_field:tenant\",\"acme\"]")
+}
+
+func TestMuxLogsRequestIds(t *testing.T) {
+	muxLogger, _ := NewHttpLoggerForMuxOptions(Options{
+		Queue:     make([]string, 0),
+		Enabled:   true,
+		Rules:     "include debug",
+		RequestId: RequestIdOptions{Enabled: true, Header: "X-Correlation-Id"},
+	})
+	var handled string
+	server := httptest.NewServer(muxLogger.LogData(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = RequestId(r.Context())
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	generated := resp.Header.Get("X-Correlation-Id")
+	assert.Equal(t, 36, len(generated))
+	assert.Equal(t, generated, handled)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("X-Correlation-Id", "req-42")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "req-42", resp.Header.Get("X-Correlation-Id"))
+	assert.Equal(t, "req-42", handled)
+
+	queue := muxLogger.HttpLogger.Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.Contains(t, queue[0], "[\"request_id\",\""+generated+"\"]")
+	assert.Contains(t, queue[1], "[\"request_id\",\"req-42\"]")
+}
@@ -0,0 +1,194 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+)
+
+// HttpLoggerForProxy defines a struct used to log API calls served through an httputil.ReverseProxy,
+// logging the inbound call and the upstream call in a single message.
+type HttpLoggerForProxy struct {
+	HttpLogger *HttpLogger
+}
+
+// context key used to pass the exchange being proxied to the proxy hooks
+type proxyExchangeKey struct{}
+
+// proxyExchange collects the upstream side of a single proxied call
+type proxyExchange struct {
+	requestId     string
+	upstreamHost  string
+	upstreamStart time.Time
+	upstreamEnd   time.Time
+	resp          *http.Response
+	respCapture   *captureReadCloser
+	err           error
+}
+
+// records the upstream host and the start of the upstream call, once the outgoing request has been prepared
+func startUpstream(outreq *http.Request) {
+	if exchange, ok := outreq.Context().Value(proxyExchangeKey{}).(*proxyExchange); ok {
+		exchange.upstreamHost = outreq.URL.Host
+		exchange.upstreamStart = time.Now()
+	}
+}
+
+// NewHttpLoggerForProxy returns a pointer to an instance of an HttpLoggerForProxy struct with the default options applied and an error.
+// If there is no error, the error value returned will be nil.
+func NewHttpLoggerForProxy() (*HttpLoggerForProxy, error) {
+	return NewHttpLoggerForProxyOptions(Options{})
+}
+
+// NewHttpLoggerForProxyOptions(o Options) returns a pointer to a HttpLoggerForProxy struct with the given options o applied and an error.
+// If there is no error, the error value returned will be nil.
+func NewHttpLoggerForProxyOptions(options Options) (*HttpLoggerForProxy, error) {
+	// unlike older loggers, which lowercase them, this logger always keeps custom field values verbatim
+	options.PreserveCustomFieldValues = true
+	HttpLogger, err := NewHttpLogger(options)
+	if err != nil {
+		return nil, err
+	}
+	return &HttpLoggerForProxy{
+		HttpLogger: HttpLogger,
+	}, nil
+}
+
+// LogProxy(p *httputil.ReverseProxy) hooks the Director (or Rewrite), ModifyResponse and ErrorHandler functions of proxy p and returns an http.Handler
+// that serves p and logs each proxied call, annotated with the upstream host and upstream latency.
+// The returned handler must be used instead of p, and LogProxy should only be called once for a given proxy.
+func (proxyLogger HttpLoggerForProxy) LogProxy(proxy *httputil.ReverseProxy) http.Handler {
+	director := proxy.Director
+	if director != nil {
+		proxy.Director = func(outreq *http.Request) {
+			director(outreq)
+			startUpstream(outreq)
+		}
+	}
+	hookRewrite(proxy)
+
+	modifyResponse := proxy.ModifyResponse
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		var exchange *proxyExchange
+		if resp.Request != nil {
+			exchange, _ = resp.Request.Context().Value(proxyExchangeKey{}).(*proxyExchange)
+		}
+		if exchange != nil {
+			exchange.upstreamEnd = time.Now()
+			if exchange.upstreamHost == "" {
+				exchange.upstreamHost = resp.Request.URL.Host
+			}
+		}
+		if modifyResponse != nil {
+			if err := modifyResponse(resp); err != nil {
+				return err
+			}
+		}
+		if exchange != nil {
+			if exchange.requestId != "" {
+				resp.Header.Set(proxyLogger.HttpLogger.requestIds.Header, exchange.requestId)
+			}
+			exchange.resp = resp
+			if resp.Body != nil && resp.Body != http.NoBody {
+				exchange.respCapture = newCaptureReadCloser(resp.Body, nil)
+				resp.Body = exchange.respCapture
+			}
+		}
+		return nil
+	}
+
+	errorHandler := proxy.ErrorHandler
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if exchange, ok := r.Context().Value(proxyExchangeKey{}).(*proxyExchange); ok {
+			exchange.err = err
+			exchange.resp = nil
+			if exchange.requestId != "" {
+				w.Header().Set(proxyLogger.HttpLogger.requestIds.Header, exchange.requestId)
+			}
+		}
+		if errorHandler != nil {
+			errorHandler(w, r, err)
+			return
+		}
+		// same as the default error handler of httputil.ReverseProxy
+		if proxy.ErrorLog != nil {
+			proxy.ErrorLog.Printf("http: proxy error: %v", err)
+		} else {
+			log.Printf("http: proxy error: %v", err)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := proxyLogger.HttpLogger
+		if !logger.Enabled() {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		exchange := &proxyExchange{}
+		ctx := WithCustomFields(r.Context())
+		exchange.requestId, ctx = logger.withRequestId(ctx, r)
+		ctx = context.WithValue(ctx, proxyExchangeKey{}, exchange)
+
+		proxiedReq := r.WithContext(ctx)
+		if exchange.requestId != "" {
+			// propagated upstream, and set on the response once the upstream has responded
+			proxiedReq.Header = r.Header.Clone()
+			proxiedReq.Header.Set(logger.requestIds.Header, exchange.requestId)
+		}
+		var reqCapture *captureReadCloser
+		if r.Body != nil && r.Body != http.NoBody {
+			reqCapture = newRequestCaptureReadCloser(r.Body, r.Header)
+			proxiedReq.Body = reqCapture
+		}
+		loggingReq := r.Clone(ctx)
+
+		now := time.Now()
+
+		proxy.ServeHTTP(w, proxiedReq)
+
+		interval := time.Since(now).Milliseconds()
+
+		if reqCapture != nil {
+			loggingReq.Body = reqCapture.Logged()
+		} else {
+			loggingReq.Body = nil
+		}
+
+		var message *HttpMessage
+		var loggingResp *http.Response
+		if exchange.resp != nil {
+			loggingResp = &http.Response{
+				StatusCode: exchange.resp.StatusCode,
+				Header:     exchange.resp.Header.Clone(),
+				Request:    loggingReq,
+			}
+			if exchange.respCapture != nil {
+				loggingResp.Body = io.NopCloser(bytes.NewReader(exchange.respCapture.Bytes()))
+			}
+			message = buildHttpMessage(loggingReq, loggingResp)
+		} else if exchange.err != nil {
+			message = buildHttpErrorMessage(loggingReq, exchange.err)
+		} else {
+			return
+		}
+
+		if exchange.upstreamHost != "" {
+			message.Add("upstream_host", exchange.upstreamHost)
+		}
+		if !exchange.upstreamStart.IsZero() && !exchange.upstreamEnd.IsZero() {
+			upstreamInterval := exchange.upstreamEnd.Sub(exchange.upstreamStart).Milliseconds()
+			message.Add("upstream_interval", strconv.FormatInt(upstreamInterval, 10))
+		}
+
+		sendHttpMessageDetails(logger, message, loggingReq, loggingResp, now.UnixNano()/int64(time.Millisecond), interval, nil)
+	})
+}
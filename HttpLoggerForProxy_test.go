@@ -0,0 +1,128 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyLoggerLogsProxiedCall(t *testing.T) {
+	upstream := newTestServer()
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	proxyLogger, err := NewHttpLoggerForProxyOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	assert.Nil(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(upstreamURL)
+	gateway := httptest.NewServer(proxyLogger.LogProxy(proxy))
+	defer gateway.Close()
+
+	resp, err := http.Post(gateway.URL+"/orders", "text/plain", strings.NewReader("hello"))
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "echo: hello", string(body))
+
+	queue := proxyLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.True(t, parseable(queue[0]))
+	assert.Contains(t, queue[0], "[\"request_url\",\""+gateway.URL+"/orders\"]")
+	assert.Contains(t, queue[0], "[\"request_body\",\"hello\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"201\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"echo: hello\"]")
+	assert.Contains(t, queue[0], "[\"upstream_host\",\""+upstreamURL.Host+"\"]")
+	assert.Contains(t, queue[0], "[\"upstream_interval\",\"")
+}
+
+func TestProxyLoggerLogsUpstreamErrors(t *testing.T) {
+	upstream := newTestServer()
+	upstreamURL, _ := url.Parse(upstream.URL)
+	upstream.Close()
+
+	proxyLogger, _ := NewHttpLoggerForProxyOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	proxy := httputil.NewSingleHostReverseProxy(upstreamURL)
+	handler := proxyLogger.LogProxy(proxy)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://gateway.test/orders", nil))
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+
+	queue := proxyLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"response_code\",\"502\"]")
+	assert.Contains(t, queue[0], "[\"response_error\",\"connection_refused: ")
+	assert.Contains(t, queue[0], "[\"upstream_host\",\""+upstreamURL.Host+"\"]")
+}
+
+func TestProxyLoggerPropagatesRequestIds(t *testing.T) {
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-Request-Id")
+		w.Header().Set("X-Request-Id", forwarded)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	proxyLogger, _ := NewHttpLoggerForProxyOptions(Options{
+		Queue:     make([]string, 0),
+		Enabled:   true,
+		Rules:     "include debug",
+		RequestId: RequestIdOptions{Enabled: true, Format: "ulid"},
+	})
+	gateway := httptest.NewServer(proxyLogger.LogProxy(httputil.NewSingleHostReverseProxy(upstreamURL)))
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 26, len(forwarded))
+	assert.Equal(t, []string{forwarded}, resp.Header.Values("X-Request-Id"))
+
+	queue := proxyLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"request_id\",\""+forwarded+"\"]")
+}
+
+func TestProxyLoggerPreservesCustomFieldValues(t *testing.T) {
+	upstream := newTestServer()
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	proxyLogger, _ := NewHttpLoggerForProxyOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	proxy := httputil.NewSingleHostReverseProxy(upstreamURL)
+	director := proxy.Director
+	proxy.Director = func(outreq *http.Request) {
+		director(outreq)
+		SetCustomField(outreq.Context(), "Order-ID", "AbC123")
+	}
+	handler := proxyLogger.LogProxy(proxy)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://gateway.test/orders", nil))
+	assert.Equal(t, 201, recorder.Code)
+
+	queue := proxyLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"custom_field:order-id\",\"AbC123\"]")
+}
@@ -5,18 +5,254 @@ package logger
 import (
 	"compress/gzip"
 	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/andybalholm/brotli"
 )
 
+// HttpMessage is an API call as logged, made of details: name and value pairs such as "request_method" and "GET".
+// Details may repeat, as with headers that have multiple values, and are submitted in order as a JSON array of pairs.
+type HttpMessage struct {
+	details [][]string
+}
+
+// NewHttpMessage returns a pointer to an empty HttpMessage, to be filled in with details of a call made over any transport.
+func NewHttpMessage() *HttpMessage {
+	return &HttpMessage{}
+}
+
+// NewHttpMessageFromDetails(d [][]string) returns a pointer to an HttpMessage with a copy of details d, as parsed from
+// access logs or received from a message queue, and an error if any detail isn't a pair of a name and a value.
+func NewHttpMessageFromDetails(details [][]string) (*HttpMessage, error) {
+	message := NewHttpMessage()
+	for i, d := range details {
+		if len(d) != 2 || d[0] == "" {
+			return nil, fmt.Errorf("invalid detail at index %d: %q", i, d)
+		}
+		message.Add(d[0], d[1])
+	}
+	return message, nil
+}
+
+// Add(n string, v string) appends a detail with name n and value v, keeping any other details with the same name.
+func (message *HttpMessage) Add(name string, value string) {
+	message.details = append(message.details, []string{name, value})
+}
+
+// Set(n string, v string) replaces all details with name n by a single detail with value v.
+func (message *HttpMessage) Set(name string, value string) {
+	for i, d := range message.details {
+		if d[0] == name {
+			d[1] = value
+			message.details = append(message.details[:i+1], removeDetails(message.details[i+1:], name)...)
+			return
+		}
+	}
+	message.Add(name, value)
+}
+
+// Get(n string) returns the value of the first detail with name n, or an empty string if there is none.
+func (message *HttpMessage) Get(name string) string {
+	for _, d := range message.details {
+		if d[0] == name {
+			return d[1]
+		}
+	}
+	return ""
+}
+
+// Values(n string) returns the values of all details with name n, in order.
+func (message *HttpMessage) Values(name string) []string {
+	var values []string
+	for _, d := range message.details {
+		if d[0] == name {
+			values = append(values, d[1])
+		}
+	}
+	return values
+}
+
+// Has(n string) returns true if the message has a detail with name n.
+func (message *HttpMessage) Has(name string) bool {
+	for _, d := range message.details {
+		if d[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove(n string) removes all details with name n.
+func (message *HttpMessage) Remove(name string) {
+	message.details = removeDetails(message.details, name)
+}
+
+// Details() returns a copy of all details, as name and value pairs.
+func (message *HttpMessage) Details() [][]string {
+	details := make([][]string, len(message.details))
+	for i, d := range message.details {
+		details[i] = []string{d[0], d[1]}
+	}
+	return details
+}
+
+// Len() returns the number of details in the message.
+func (message *HttpMessage) Len() int {
+	return len(message.details)
+}
+
+// MarshalJSON() returns the message as submitted, a JSON array of name and value pairs.
+func (message *HttpMessage) MarshalJSON() ([]byte, error) {
+	if message.details == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(message.details)
+}
+
+// UnmarshalJSON(b []byte) replaces the details of the message with those of b, a JSON array of name and value pairs.
+func (message *HttpMessage) UnmarshalJSON(data []byte) error {
+	var details [][]string
+	if err := json.Unmarshal(data, &details); err != nil {
+		return err
+	}
+	parsed, err := NewHttpMessageFromDetails(details)
+	if err != nil {
+		return err
+	}
+	message.details = parsed.details
+	return nil
+}
+
+// RequestMethod() returns the request_method detail.
+func (message *HttpMessage) RequestMethod() string {
+	return message.Get("request_method")
+}
+
+// SetRequestMethod(m string) sets the request_method detail to m.
+func (message *HttpMessage) SetRequestMethod(method string) {
+	message.Set("request_method", method)
+}
+
+// RequestURL() returns the request_url detail.
+func (message *HttpMessage) RequestURL() string {
+	return message.Get("request_url")
+}
+
+// SetRequestURL(u string) sets the request_url detail to u, which should be an absolute URL.
+func (message *HttpMessage) SetRequestURL(url string) {
+	message.Set("request_url", url)
+}
+
+// RequestHeader(n string) returns the first value of request header n, which is case-insensitive.
+func (message *HttpMessage) RequestHeader(name string) string {
+	return message.Get("request_header:" + strings.ToLower(name))
+}
+
+// AddRequestHeader(n string, v string) adds value v to request header n.
+func (message *HttpMessage) AddRequestHeader(name string, value string) {
+	message.Add("request_header:"+strings.ToLower(name), value)
+}
+
+// RequestParam(n string) returns the first value of request parameter n, which is case-insensitive.
+func (message *HttpMessage) RequestParam(name string) string {
+	return message.Get("request_param:" + strings.ToLower(name))
+}
+
+// AddRequestParam(n string, v string) adds value v to request parameter n.
+func (message *HttpMessage) AddRequestParam(name string, value string) {
+	message.Add("request_param:"+strings.ToLower(name), value)
+}
+
+// RequestBody() returns the request_body detail.
+func (message *HttpMessage) RequestBody() string {
+	return message.Get("request_body")
+}
+
+// SetRequestBody(b string) sets the request_body detail to b.
+func (message *HttpMessage) SetRequestBody(body string) {
+	message.Set("request_body", body)
+}
+
+// ResponseCode() returns the response_code detail, or 0 if it is missing or not a number.
+func (message *HttpMessage) ResponseCode() int {
+	code, _ := strconv.Atoi(message.Get("response_code"))
+	return code
+}
+
+// SetResponseCode(c int) sets the response_code detail to c.
+func (message *HttpMessage) SetResponseCode(code int) {
+	message.Set("response_code", strconv.Itoa(code))
+}
+
+// ResponseHeader(n string) returns the first value of response header n, which is case-insensitive.
+func (message *HttpMessage) ResponseHeader(name string) string {
+	return message.Get("response_header:" + strings.ToLower(name))
+}
+
+// AddResponseHeader(n string, v string) adds value v to response header n.
+func (message *HttpMessage) AddResponseHeader(name string, value string) {
+	message.Add("response_header:"+strings.ToLower(name), value)
+}
+
+// ResponseBody() returns the response_body detail.
+func (message *HttpMessage) ResponseBody() string {
+	return message.Get("response_body")
+}
+
+// SetResponseBody(b string) sets the response_body detail to b.
+func (message *HttpMessage) SetResponseBody(body string) {
+	message.Set("response_body", body)
+}
+
+// Now() returns the now detail, the time the call started in milliseconds since the epoch, or 0 if it is missing.
+func (message *HttpMessage) Now() int64 {
+	now, _ := strconv.ParseInt(message.Get("now"), 10, 64)
+	return now
+}
+
+// SetNow(n int64) sets the now detail to n, in milliseconds since the epoch.
+func (message *HttpMessage) SetNow(now int64) {
+	message.Set("now", strconv.FormatInt(now, 10))
+}
+
+// Interval() returns the interval detail, the duration of the call in milliseconds, or 0 if it is missing.
+func (message *HttpMessage) Interval() int64 {
+	interval, _ := strconv.ParseInt(message.Get("interval"), 10, 64)
+	return interval
+}
+
+// SetInterval(i int64) sets the interval detail to i, in milliseconds.
+func (message *HttpMessage) SetInterval(interval int64) {
+	message.Set("interval", strconv.FormatInt(interval, 10))
+}
+
+// returns details without those named name, reusing the same array
+func removeDetails(details [][]string, name string) [][]string {
+	i := 0
+	for _, d := range details {
+		if d[0] != name {
+			details[i] = d
+			i++
+		}
+	}
+	return details[:i]
+}
+
 // helper function to read body bytes
 func readBody(rBody io.ReadCloser, encoding string) (string, error) {
 	const bodyLimit = 1024 * 1024
@@ -53,20 +289,21 @@ func readBody(rBody io.ReadCloser, encoding string) (string, error) {
 }
 
 // create Http message for any logger
-func buildHttpMessage(req *http.Request, resp *http.Response) [][]string {
-	var message [][]string
+func buildHttpMessage(req *http.Request, resp *http.Response) *HttpMessage {
+	message := NewHttpMessage()
 
 	method := req.Method
 	if method != "" {
-		message = append(message, []string{"request_method", method})
+		message.Add("request_method", method)
 	}
 
-	//protocol is not currently used
-	// message = append(message, []string{"request_protocol", req.Proto})
-
 	var fullUrl string
 	if req.URL.IsAbs() {
 		fullUrl = req.RequestURI
+		// outgoing client requests have no RequestURI
+		if fullUrl == "" {
+			fullUrl = req.URL.String()
+		}
 	} else {
 		//Not sure of a better way to do this at the moment - 6/24/21
 		//check for other tls proto
@@ -76,33 +313,44 @@ func buildHttpMessage(req *http.Request, resp *http.Response) [][]string {
 			fullUrl = "https://" + req.Host + req.URL.Path
 		}
 		// ---
+		if req.URL.RawQuery != "" {
+			fullUrl += "?" + req.URL.RawQuery
+		}
 	}
 
-	message = append(message, []string{"request_url", fullUrl})
-	message = append(message, []string{"response_code", fmt.Sprint(resp.StatusCode)})
+	message.Add("request_url", fullUrl)
+	message.Add("response_code", fmt.Sprint(resp.StatusCode))
 
+	var requestBody string
 	if req.Body != nil {
-		var contentEncoding string
-		if encodings, encoded := req.Header["Content-Encoding"]; encoded {
-			contentEncoding = encodings[0]
-		}
-		requestBody, err := readBody(req.Body, contentEncoding)
-		if err != nil {
-			log.Println(err)
+		// multipart bodies are summarized by appendMultipartDetails rather than logged
+		if multipartBoundary(req.Header) == "" {
+			var contentEncoding string
+			if encodings, encoded := req.Header["Content-Encoding"]; encoded {
+				contentEncoding = encodings[0]
+			}
+			var err error
+			requestBody, err = readBody(req.Body, contentEncoding)
+			if err != nil {
+				log.Println(err)
+			}
+			message.Add("request_body", requestBody)
 		}
-		message = append(message, []string{"request_body", requestBody})
 
 		// Unescaped semicolons in querystring make ParseForm return a non-nil error
 		req.URL.RawQuery = strings.ReplaceAll(req.URL.RawQuery, ";", "%3B")
-		err = req.ParseForm()
+		err := req.ParseForm()
 		if err != nil {
 			log.Println(err)
 		}
 	}
 
-	appendRequestHeaders(&message, req)
-	appendRequestParams(&message, req)
-	appendResponseHeaders(&message, resp)
+	appendRequestHeaders(message, req)
+	appendRequestParams(message, req)
+	appendMultipartDetails(message, req)
+	appendGraphqlDetails(message, req, requestBody)
+	appendTraceDetails(message, req)
+	appendResponseHeaders(message, resp)
 
 	if resp.Body != nil {
 		var contentEncoding string
@@ -113,7 +361,7 @@ func buildHttpMessage(req *http.Request, resp *http.Response) [][]string {
 		if err != nil {
 			log.Println(err)
 		}
-		message = append(message, []string{"response_body", responseBody})
+		message.Add("response_body", responseBody)
 	}
 
 	return message
@@ -130,6 +378,113 @@ func SendHttpMessage(logger *HttpLogger, resp *http.Response, req *http.Request,
 
 	// copy details from request & response
 	message := buildHttpMessage(req, resp)
+
+	sendHttpMessageDetails(logger, message, req, resp, now, interval, customFields)
+}
+
+// SubmitHttpMessage(l *HttpLogger, m *HttpMessage, customFields map[string]string) Uses logger l to send message m to the loggers url,
+// for calls made over transports other than net/http. Rules are applied as with SendHttpMessage, and m is left unchanged.
+// If m has no now or interval details, these are set to the current time and 1 millisecond respectively, and if m has
+// no host detail, it is set to the host the logger runs on.
+func SubmitHttpMessage(logger *HttpLogger, message *HttpMessage, customFields map[string]string) {
+
+	if !logger.Enabled() {
+		return
+	}
+
+	// rules change details in place
+	submitted := &HttpMessage{details: message.Details()}
+	if !submitted.Has("now") {
+		submitted.SetNow(time.Now().UnixNano() / int64(time.Millisecond))
+	}
+	if !submitted.Has("interval") {
+		submitted.SetInterval(1)
+	}
+	logger.enrich(submitted, nil, nil)
+
+	if logger.submitIfPassing(submitted, customFields) {
+		logger.notifySubmitted(submitted, nil, nil)
+	}
+}
+
+// SendHttpErrorMessage(l *HttpLogger, req *http.Request, err error, now int64, interval int64) Uses logger l to send a log of a request that failed without a response,
+// such as a transport error or timeout. A synthetic response code is logged along with a response_error detail carrying the error class and message.
+func SendHttpErrorMessage(logger *HttpLogger, req *http.Request, err error, now int64, interval int64, customFields map[string]string) {
+
+	if !logger.Enabled() {
+		return
+	}
+
+	// copy details from request & error
+	message := buildHttpErrorMessage(req, err)
+
+	sendHttpMessageDetails(logger, message, req, nil, now, interval, customFields)
+}
+
+// adds the HTTP protocol version, and for connections over TLS, the TLS version, cipher suite, server name and
+// client certificate subject. Clients only know these from the response.
+func appendConnectionDetails(message *HttpMessage, req *http.Request, resp *http.Response) {
+	proto, state := req.Proto, req.TLS
+	if resp != nil {
+		if resp.Proto != "" {
+			proto = resp.Proto
+		}
+		if state == nil {
+			state = resp.TLS
+		}
+	}
+
+	if proto != "" {
+		message.Add("request_protocol", proto)
+	}
+	if state == nil || state.Version == 0 {
+		return
+	}
+	message.Add("request_tls_version", tlsVersionName(state.Version))
+	message.Add("request_tls_cipher", tls.CipherSuiteName(state.CipherSuite))
+	if state.ServerName != "" {
+		message.Add("request_tls_server_name", state.ServerName)
+	}
+	// peer certificates are those of the server when logging clients
+	if len(state.PeerCertificates) > 0 && req.TLS != nil {
+		message.Add("request_tls_client_subject", state.PeerCertificates[0].Subject.String())
+	}
+}
+
+// returns the name of a TLS version, as tls.VersionName does in later Go versions
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionSSL30:
+		return "SSLv3"
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// create Http message for a request that failed without a response
+func buildHttpErrorMessage(req *http.Request, err error) *HttpMessage {
+	class := errorClass(err)
+	resp := &http.Response{
+		StatusCode: errorResponseCode(class),
+		Header:     http.Header{},
+	}
+
+	message := buildHttpMessage(req, resp)
+	message.Add("response_error", class+": "+errorText(err))
+
+	return message
+}
+
+// add session fields, now, interval and enriched details to message details and submit them, along with custom fields
+// attached to the request context
+func sendHttpMessageDetails(logger *HttpLogger, message *HttpMessage, req *http.Request, resp *http.Response, now int64, interval int64, customFields map[string]string) {
 	copySessionField := logger.rules.CopySessionField()
 
 	// copy data from session if configured
@@ -142,8 +497,7 @@ func SendHttpMessage(logger *HttpLogger, resp *http.Response, req *http.Request,
 					matched := r.param1.(*regexp.Regexp).MatchString(name)
 					if matched {
 						cookieVal := cookie.Value
-						message = append(message,
-							[]string{"session_field:" + name, cookieVal})
+						message.Add("session_field:"+name, cookieVal)
 					}
 				}
 			}
@@ -153,27 +507,99 @@ func SendHttpMessage(logger *HttpLogger, resp *http.Response, req *http.Request,
 	if now == 0 {
 		now = time.Now().UnixNano() / int64(time.Millisecond)
 	}
-	message = append(message, []string{"now", strconv.FormatInt(now, 10)})
+	message.Add("now", strconv.FormatInt(now, 10))
 
 	// append interval noting the time between request and response
 	if interval != 0 {
-		message = append(message, []string{"interval", strconv.FormatInt(interval, 10)})
+		message.Add("interval", strconv.FormatInt(interval, 10))
 	} else {
-		message = append(message, []string{"interval", strconv.FormatInt(1, 10)})
+		message.Add("interval", strconv.FormatInt(1, 10))
+	}
+
+	if logger.connectionDetails {
+		appendConnectionDetails(message, req, resp)
+	}
+	if clientIp := logger.clientIp(req); clientIp != "" {
+		message.Add("request_client_ip", clientIp)
 	}
+	if requestId := RequestId(req.Context()); requestId != "" && !message.Has("request_id") {
+		message.Add("request_id", requestId)
+	}
+
+	logger.enrich(message, req, resp)
+
+	if logger.submitIfPassing(message, mergeCustomFields(req.Context(), customFields)) {
+		logger.notifySubmitted(message, req, resp)
+	}
+}
+
+// returns the text of a failed call's error, leaving out the URL added by *url.Error, which would get around rules
+// on request_url, like those removing the query string
+func errorText(err error) string {
+	text := err.Error()
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		text = strings.Replace(text, urlError.Error(), urlError.Op+": "+urlError.Err.Error(), 1)
+	}
+	return text
+}
+
+/*
+* Returns the class of a failed call's error.
+ */
+func errorClass(err error) string {
+	var netError net.Error
+	var dnsError *net.DNSError
+	var opError *net.OpError
+	var unknownAuthorityError x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	var certificateInvalidError x509.CertificateInvalidError
+	var recordHeaderError tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsError):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	case errors.As(err, &unknownAuthorityError), errors.As(err, &hostnameError),
+		errors.As(err, &certificateInvalidError), errors.As(err, &recordHeaderError):
+		return "tls"
+	case errors.As(err, &opError):
+		return "network"
+	default:
+		return "error"
+	}
+}
 
-	logger.submitIfPassing(message, customFields)
+/*
+* Returns the synthetic response code logged for a given error class.
+ */
+func errorResponseCode(class string) int {
+	switch class {
+	case "canceled":
+		return 499
+	case "timeout":
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
 }
 
 /*
 * Adds response headers to message.
  */
-func appendResponseHeaders(message *[][]string, resp *http.Response) {
+func appendResponseHeaders(message *HttpMessage, resp *http.Response) {
 	respHeader := resp.Header
 	for headerName, headerValues := range respHeader {
 		name := "response_header:" + strings.ToLower(headerName)
 		for _, value := range headerValues {
-			*message = append(*message, []string{name, value})
+			message.Add(name, value)
 		}
 	}
 }
@@ -181,12 +607,12 @@ func appendResponseHeaders(message *[][]string, resp *http.Response) {
 /*
 * Adds request params to message.
  */
-func appendRequestParams(message *[][]string, req *http.Request) {
+func appendRequestParams(message *HttpMessage, req *http.Request) {
 	reqParams := req.Form
 	for paramName, params := range reqParams {
 		name := "request_param:" + strings.ToLower(paramName)
 		for _, param := range params {
-			*message = append(*message, []string{name, param})
+			message.Add(name, param)
 		}
 	}
 }
@@ -194,20 +620,17 @@ func appendRequestParams(message *[][]string, req *http.Request) {
 /*
 * Adds request headers to message.
  */
-func appendRequestHeaders(message *[][]string, req *http.Request) {
+func appendRequestHeaders(message *HttpMessage, req *http.Request) {
 	reqHeaders := req.Header
 	for headerName, headerValues := range reqHeaders {
 		name := "request_header:" + strings.ToLower(headerName)
 		for _, value := range headerValues {
-			*message = append(*message, []string{name, value})
+			message.Add(name, value)
 		}
 	}
 
-	if req.RemoteAddr != "" {
-		addr := req.RemoteAddr
-		if idx := strings.Index(addr, ":"); idx != -1 {
-			addr = addr[:idx]
-		}
-		*message = append(*message, []string{"request_header:x-forwarded-for", addr})
+	// keep any chain set by proxies, which request_client_ip resolves
+	if req.RemoteAddr != "" && len(reqHeaders.Values("X-Forwarded-For")) == 0 {
+		message.Add("request_header:x-forwarded-for", remoteHost(req.RemoteAddr))
 	}
 }
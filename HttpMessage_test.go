@@ -0,0 +1,213 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildsHttpMessage(t *testing.T) {
+	message := NewHttpMessage()
+	assert.Equal(t, 0, message.Len())
+	assert.Equal(t, "", message.Get("request_method"))
+	assert.False(t, message.Has("request_method"))
+
+	message.SetRequestMethod("PUBLISH")
+	message.SetRequestURL("amqp://broker/orders")
+	message.AddRequestHeader("X-Tenant", "a")
+	message.AddRequestHeader("x-tenant", "b")
+	message.AddRequestParam("Q", "1")
+	message.SetRequestBody("{}")
+	message.SetResponseCode(202)
+	message.AddResponseHeader("Content-Type", "application/json")
+	message.SetResponseBody("ok")
+	message.SetNow(1455908640173)
+	message.SetInterval(42)
+
+	assert.Equal(t, "PUBLISH", message.RequestMethod())
+	assert.Equal(t, "amqp://broker/orders", message.RequestURL())
+	assert.Equal(t, "a", message.RequestHeader("X-TENANT"))
+	assert.Equal(t, []string{"a", "b"}, message.Values("request_header:x-tenant"))
+	assert.Equal(t, "1", message.RequestParam("q"))
+	assert.Equal(t, "{}", message.RequestBody())
+	assert.Equal(t, 202, message.ResponseCode())
+	assert.Equal(t, "application/json", message.ResponseHeader("content-type"))
+	assert.Equal(t, "ok", message.ResponseBody())
+	assert.Equal(t, int64(1455908640173), message.Now())
+	assert.Equal(t, int64(42), message.Interval())
+	assert.Equal(t, 11, message.Len())
+
+	message.Set("request_header:x-tenant", "c")
+	assert.Equal(t, []string{"c"}, message.Values("request_header:x-tenant"))
+	assert.Equal(t, 10, message.Len())
+
+	message.Remove("response_body")
+	assert.False(t, message.Has("response_body"))
+	assert.Equal(t, 9, message.Len())
+
+	details := message.Details()
+	details[0][1] = "changed"
+	assert.Equal(t, "PUBLISH", message.RequestMethod(), "details not copied")
+}
+
+func TestMarshalsHttpMessage(t *testing.T) {
+	marshalled, err := json.Marshal(NewHttpMessage())
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", string(marshalled))
+
+	message := NewHttpMessage()
+	message.SetRequestMethod("GET")
+	message.SetResponseCode(200)
+	marshalled, err = json.Marshal(message)
+	assert.Nil(t, err)
+	assert.Equal(t, `[["request_method","GET"],["response_code","200"]]`, string(marshalled))
+}
+
+func TestSubmitsHttpMessage(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{
+		Rules: "include debug\n/request_body/ replace /secret/, /xxx/",
+		Queue: make([]string, 0),
+	})
+
+	message := NewHttpMessage()
+	message.SetRequestMethod("PUBLISH")
+	message.SetRequestURL("amqp://broker/orders")
+	message.SetRequestBody("my secret")
+	message.SetResponseCode(202)
+	SubmitHttpMessage(logger, message, map[string]string{"queue": "orders"})
+
+	assert.Equal(t, 1, len(logger.Queue()))
+	msg := logger.Queue()[0]
+	assert.True(t, parseable(msg))
+	assert.Contains(t, msg, "[\"request_method\",\"PUBLISH\"]")
+	assert.Contains(t, msg, "[\"request_body\",\"my xxx\"]")
+	assert.Contains(t, msg, "[\"custom_field:queue\",\"orders\"]")
+	assert.Contains(t, msg, "[\"interval\",\"1\"]")
+	assert.Contains(t, msg, "[\"host\",")
+	assert.True(t, strings.Contains(msg, "[\"now\",\""), "now not set")
+	assert.Equal(t, "my secret", message.RequestBody(), "message changed by rules")
+	assert.False(t, message.Has("now"), "message changed by submission")
+
+	message.SetNow(1455908640173)
+	message.SetInterval(42)
+	SubmitHttpMessage(logger, message, nil)
+	assert.Equal(t, 2, len(logger.Queue()))
+	assert.Contains(t, logger.Queue()[1], "[\"now\",\"1455908640173\"],[\"interval\",\"42\"]")
+}
+
+func TestParsesHttpMessage(t *testing.T) {
+	message, err := NewHttpMessageFromDetails([][]string{{"request_method", "GET"}, {"response_code", "200"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "GET", message.RequestMethod())
+	assert.Equal(t, 200, message.ResponseCode())
+
+	_, err = NewHttpMessageFromDetails([][]string{{"request_method", "GET"}, {"response_code"}})
+	assert.Equal(t, "invalid detail at index 1: [\"response_code\"]", err.Error())
+	_, err = NewHttpMessageFromDetails([][]string{{"", "GET"}})
+	assert.NotNil(t, err)
+
+	message = NewHttpMessage()
+	assert.Nil(t, json.Unmarshal([]byte(`[["request_method","GET"],["request_url","http://localhost/"]]`), message))
+	assert.Equal(t, "http://localhost/", message.RequestURL())
+	assert.NotNil(t, json.Unmarshal([]byte(`[["request_method","GET","extra"]]`), message))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"request_method":"GET"}`), message))
+	assert.Equal(t, "http://localhost/", message.RequestURL(), "message changed by invalid JSON")
+}
+
+func TestSubmitsDetails(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{
+		Rules: "include debug\n/request_header:authorization/ remove",
+		Queue: make([]string, 0),
+	})
+
+	err := logger.Submit([][]string{
+		{"request_method", "GET"},
+		{"request_url", "http://example.com/orders"},
+		{"request_header:authorization", "Bearer abc"},
+		{"response_code", "200"},
+		{"now", "1455908640173"},
+		{"host", "web-1"},
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logger.Queue()))
+	msg := logger.Queue()[0]
+	assert.NotContains(t, msg, "authorization")
+	assert.Contains(t, msg, "[\"now\",\"1455908640173\"]")
+	assert.Contains(t, msg, "[\"interval\",\"1\"]")
+	assert.Contains(t, msg, "[\"host\",\"web-1\"]")
+	assert.Equal(t, 1, strings.Count(msg, "\"host\""))
+
+	err = logger.Submit([][]string{{"request_method"}}, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(logger.Queue()))
+}
+
+func TestBuildsFullUrls(t *testing.T) {
+	req := MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.URL, _ = url.Parse("/orders?page=2&token=abc")
+	req.RequestURI = "/orders?page=2&token=abc"
+	resp := MockGetPlainTextResponse(&req)
+	message := buildHttpMessage(&req, &resp)
+	assert.Equal(t, "http://testing.dev/orders?page=2&token=abc", message.RequestURL())
+
+	logger, _ := NewHttpLogger(Options{
+		Queue: make([]string, 0),
+		Rules: "include debug\n/request_url/ replace /token=[^&]*/, /token=xxx/",
+	})
+	req.Body = io.NopCloser(strings.NewReader("{}"))
+	resp = MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+	assert.Contains(t, logger.Queue()[0], "[\"request_url\",\"http://testing.dev/orders?page=2\\u0026token=xxx\"]")
+}
+
+func TestLogsConnectionDetails(t *testing.T) {
+	req := MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.Proto = "HTTP/2.0"
+	req.TLS = &tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		ServerName:       "api.example.com",
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "client-1", Organization: []string{"Acme"}}}},
+	}
+	resp := MockGetPlainTextResponse(&req)
+	resp.Proto = ""
+
+	message := NewHttpMessage()
+	appendConnectionDetails(message, &req, &resp)
+	assert.Equal(t, "HTTP/2.0", message.Get("request_protocol"))
+	assert.Equal(t, "TLS 1.3", message.Get("request_tls_version"))
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", message.Get("request_tls_cipher"))
+	assert.Equal(t, "api.example.com", message.Get("request_tls_server_name"))
+	assert.Equal(t, "CN=client-1,O=Acme", message.Get("request_tls_client_subject"))
+
+	// clients only see the connection from the response, with the certificate of the server
+	client := MockGetRequestWithBody([]byte("{}"), "application/json")
+	resp = MockGetPlainTextResponse(&client)
+	resp.TLS = req.TLS
+	message = NewHttpMessage()
+	appendConnectionDetails(message, &client, &resp)
+	assert.Equal(t, "HTTP/1.1", message.Get("request_protocol"))
+	assert.Equal(t, "TLS 1.3", message.Get("request_tls_version"))
+	assert.False(t, message.Has("request_tls_client_subject"))
+
+	for _, enabled := range []bool{false, true} {
+		logger, _ := NewHttpLogger(Options{
+			Queue:             make([]string, 0),
+			Rules:             "include debug",
+			ConnectionDetails: enabled,
+		})
+		req.Body = io.NopCloser(strings.NewReader("{}"))
+		resp = MockGetPlainTextResponse(&req)
+		SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+		assert.Equal(t, enabled, strings.Contains(logger.Queue()[0], "[\"request_tls_version\",\"TLS 1.3\"]"))
+	}
+}
@@ -7,10 +7,11 @@ import (
 )
 
 type HttpRule struct {
-	verb   string
-	scope  *regexp.Regexp
-	param1 interface{}
-	param2 interface{}
+	verb       string
+	scope      *regexp.Regexp
+	param1     interface{}
+	param2     interface{}
+	conditions []ruleCondition
 }
 
 func NewHttpRule(_verb string, _scope *regexp.Regexp,
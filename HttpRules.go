@@ -4,7 +4,6 @@ package logger
 
 import (
 	"fmt"
-	"math/rand"
 	"os"
 	"regexp"
 	"strconv"
@@ -27,28 +26,39 @@ var httpRules *HttpRules
 
 // struct for rules that are applied to http logging messages
 type HttpRules struct {
-	debugRules        string
-	standardRules     string
-	strictRules       string
-	defaultRules      string
-	allowHttpUrl      bool
-	copySessionField  []*HttpRule
-	remove            []*HttpRule
-	removeIf          []*HttpRule
-	removeIfFound     []*HttpRule
-	removeUnless      []*HttpRule
-	removeUnlessFound []*HttpRule
-	replace           []*HttpRule
-	sample            []*HttpRule
-	skipCompression   bool
-	skipSubmission    bool
-	size              int
-	stop              []*HttpRule
-	stopIf            []*HttpRule
-	stopIfFound       []*HttpRule
-	stopUnless        []*HttpRule
-	stopUnlessFound   []*HttpRule
-	text              string
+	debugRules          string
+	standardRules       string
+	strictRules         string
+	defaultRules        string
+	allowHeaders        []*HttpRule
+	allowHttpUrl        bool
+	copySessionField    []*HttpRule
+	hash                []*HttpRule
+	hashKey             []byte
+	keepOnly            []*HttpRule
+	limitPerMinute      []*HttpRule
+	maskGraphqlVariable []*HttpRule
+	maskPii             []*HttpRule
+	remove              []*HttpRule
+	removeIf            []*HttpRule
+	removeIfFound       []*HttpRule
+	removeJson          []*HttpRule
+	removeUnless        []*HttpRule
+	removeUnlessFound   []*HttpRule
+	replace             []*HttpRule
+	replaceJson         []*HttpRule
+	sample              []*HttpRule
+	skipCompression     bool
+	skipSubmission      bool
+	size                int
+	stop                []*HttpRule
+	stopIf              []*HttpRule
+	stopIfFound         []*HttpRule
+	stopUnless          []*HttpRule
+	stopUnlessFound     []*HttpRule
+	text                string
+	tokenize            []*HttpRule
+	truncate            []*HttpRule
 }
 
 // get package global httpRules containing default rules sets
@@ -61,7 +71,7 @@ func GetHttpRules() *HttpRules {
 			"/request_body|request_param|response_body/ replace /[0-9\\.\\-\\/]{9,}/, /xyxy/\n"
 
 		_strictRules := "/request_url/ replace /([^\\?;]+).*/, /$1/\n" +
-			"/request_body|response_body|request_param:.*|request_header:(user-agent).*|response_header:((content-length)|(content-type)).*/ remove\n"
+			"/request_body|response_body|request_param:.*|request_file:.*|graphql_variable:.*|request_header:(user-agent).*|response_header:((content-length)|(content-type)).*/ remove\n"
 
 		_defaultRules := _strictRules
 		httpRules = &HttpRules{
@@ -136,19 +146,27 @@ func newHttpRules(rules string) (*HttpRules, error) {
 		"/request_body|request_param|response_body/ replace /[0-9\\.\\-\\/]{9,}/, /xyxy/\n"
 
 	_strictRules := "/request_url/ replace /([^\\?;]+).*/, /$1/\n" +
-		"/request_body|response_body|request_param:.*|request_header:(user-agent).*|response_header:((content-length)|(content-type)).*/ remove\n"
+		"/request_body|response_body|request_param:.*|request_file:.*|graphql_variable:.*|request_header:(user-agent).*|response_header:((content-length)|(content-type)).*/ remove\n"
 
 	_defaultRules := _strictRules
 
 	// break out rules by verb
+	_allowHeaders := ruleFilter(prs, "allow_headers", ruleCompare)
 	_allowHttpUrl := len(ruleFilter(prs, "allow_http_url", ruleCompare)) > 0
 	_copySessionField := ruleFilter(prs, "copy_session_field", ruleCompare)
+	_hash := ruleFilter(prs, "hash", ruleCompare)
+	_keepOnly := ruleFilter(prs, "keep_only", ruleCompare)
+	_limitPerMinute := ruleFilter(prs, "limit_per_minute", ruleCompare)
+	_maskGraphqlVariable := ruleFilter(prs, "mask_graphql_variable", ruleCompare)
+	_maskPii := ruleFilter(prs, "mask_pii", ruleCompare)
 	_remove := ruleFilter(prs, "remove", ruleCompare)
 	_removeIf := ruleFilter(prs, "remove_if", ruleCompare)
 	_removeIfFound := ruleFilter(prs, "remove_if_found", ruleCompare)
+	_removeJson := ruleFilter(prs, "remove_json", ruleCompare)
 	_removeUnless := ruleFilter(prs, "remove_unless", ruleCompare)
 	_removeUnlessFound := ruleFilter(prs, "remove_unless_found", ruleCompare)
 	_replace := ruleFilter(prs, "replace", ruleCompare)
+	_replaceJson := ruleFilter(prs, "replace_json", ruleCompare)
 	_sample := ruleFilter(prs, "sample", ruleCompare)
 	_skipCompression := len(ruleFilter(prs, "skip_compression", ruleCompare)) > 0
 	_skipSubmission := len(ruleFilter(prs, "skip_submission", ruleCompare)) > 0
@@ -157,34 +175,42 @@ func newHttpRules(rules string) (*HttpRules, error) {
 	_stopIfFound := ruleFilter(prs, "stop_if_found", ruleCompare)
 	_stopUnless := ruleFilter(prs, "stop_unless", ruleCompare)
 	_stopUnlessFound := ruleFilter(prs, "stop_unless_found", ruleCompare)
-
-	if len(_sample) > 1 {
-		return nil, fmt.Errorf("multiple sample rules")
-	}
+	_tokenize := ruleFilter(prs, "tokenize", ruleCompare)
+	_truncate := ruleFilter(prs, "truncate", ruleCompare)
 
 	return &HttpRules{
-		debugRules:        _debugRules,
-		standardRules:     _standardRules,
-		strictRules:       _strictRules,
-		defaultRules:      _defaultRules,
-		allowHttpUrl:      _allowHttpUrl,
-		copySessionField:  _copySessionField,
-		remove:            _remove,
-		removeIf:          _removeIf,
-		removeIfFound:     _removeIfFound,
-		removeUnless:      _removeUnless,
-		removeUnlessFound: _removeUnlessFound,
-		replace:           _replace,
-		sample:            _sample,
-		skipCompression:   _skipCompression,
-		skipSubmission:    _skipSubmission,
-		size:              _size,
-		stop:              _stop,
-		stopIf:            _stopIf,
-		stopIfFound:       _stopIfFound,
-		stopUnless:        _stopUnless,
-		stopUnlessFound:   _stopUnlessFound,
-		text:              _text,
+		debugRules:          _debugRules,
+		standardRules:       _standardRules,
+		strictRules:         _strictRules,
+		defaultRules:        _defaultRules,
+		allowHeaders:        _allowHeaders,
+		allowHttpUrl:        _allowHttpUrl,
+		copySessionField:    _copySessionField,
+		hash:                _hash,
+		keepOnly:            _keepOnly,
+		limitPerMinute:      _limitPerMinute,
+		maskGraphqlVariable: _maskGraphqlVariable,
+		maskPii:             _maskPii,
+		remove:              _remove,
+		removeIf:            _removeIf,
+		removeIfFound:       _removeIfFound,
+		removeJson:          _removeJson,
+		removeUnless:        _removeUnless,
+		removeUnlessFound:   _removeUnlessFound,
+		replace:             _replace,
+		replaceJson:         _replaceJson,
+		sample:              _sample,
+		skipCompression:     _skipCompression,
+		skipSubmission:      _skipSubmission,
+		size:                _size,
+		stop:                _stop,
+		stopIf:              _stopIf,
+		stopIfFound:         _stopIfFound,
+		stopUnless:          _stopUnless,
+		stopUnlessFound:     _stopUnlessFound,
+		text:                _text,
+		tokenize:            _tokenize,
+		truncate:            _truncate,
 	}, nil // error is nil
 }
 
@@ -210,6 +236,10 @@ func (rules *HttpRules) StrictRules() string {
 	return rules.strictRules
 }
 
+func (rules *HttpRules) AllowHeaders() []*HttpRule {
+	return rules.allowHeaders
+}
+
 func (rules *HttpRules) AllowHttpUrl() bool {
 	return rules.allowHttpUrl
 }
@@ -218,6 +248,26 @@ func (rules *HttpRules) CopySessionField() []*HttpRule {
 	return rules.copySessionField
 }
 
+func (rules *HttpRules) Hash() []*HttpRule {
+	return rules.hash
+}
+
+func (rules *HttpRules) KeepOnly() []*HttpRule {
+	return rules.keepOnly
+}
+
+func (rules *HttpRules) LimitPerMinute() []*HttpRule {
+	return rules.limitPerMinute
+}
+
+func (rules *HttpRules) MaskGraphqlVariable() []*HttpRule {
+	return rules.maskGraphqlVariable
+}
+
+func (rules *HttpRules) MaskPii() []*HttpRule {
+	return rules.maskPii
+}
+
 func (rules *HttpRules) Remove() []*HttpRule {
 	return rules.remove
 }
@@ -230,6 +280,10 @@ func (rules *HttpRules) RemoveIfFound() []*HttpRule {
 	return rules.removeIfFound
 }
 
+func (rules *HttpRules) RemoveJson() []*HttpRule {
+	return rules.removeJson
+}
+
 func (rules *HttpRules) RemoveUnless() []*HttpRule {
 	return rules.removeUnless
 }
@@ -242,6 +296,10 @@ func (rules *HttpRules) Replace() []*HttpRule {
 	return rules.replace
 }
 
+func (rules *HttpRules) ReplaceJson() []*HttpRule {
+	return rules.replaceJson
+}
+
 func (rules *HttpRules) Sample() []*HttpRule {
 	return rules.sample
 }
@@ -282,15 +340,46 @@ func (rules *HttpRules) Text() string {
 	return rules.text
 }
 
+func (rules *HttpRules) Tokenize() []*HttpRule {
+	return rules.tokenize
+}
+
+func (rules *HttpRules) Truncate() []*HttpRule {
+	return rules.truncate
+}
+
 // parse rule from single line
 func parseRule(r string) (*HttpRule, error) {
 	if r == "" || regexBlankOrComment.MatchString(r) {
 		return nil, nil
 	}
+	if c := regexRuleConditions.FindStringSubmatch(r); c != nil {
+		conditions, err := parseRuleConditions(r, c[2])
+		if err != nil {
+			return nil, err
+		}
+		rule, err := parseRule(c[1])
+		if err != nil {
+			return nil, err
+		}
+		if rule == nil || !conditionalVerbs[rule.verb] {
+			return nil, fmt.Errorf("conditions not supported in rule: %s", r)
+		}
+		rule.conditions = conditions
+		return rule, nil
+	}
+	m := regexAllowHeaders.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegexExact(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("allow_headers", nil, parsedRegex, nil), nil
+	}
 	if regexAllowHttpUrl.MatchString(r) {
 		return NewHttpRule("allow_http_url", nil, nil, nil), nil
 	}
-	m := regexCopySessionField.FindAllStringSubmatch(r, -1)
+	m = regexCopySessionField.FindAllStringSubmatch(r, -1)
 	if m != nil {
 		parsedRegex, err := parseRegex(r, m[0][1])
 		if err != nil {
@@ -298,6 +387,75 @@ func parseRule(r string) (*HttpRule, error) {
 		}
 		return NewHttpRule("copy_session_field", nil, parsedRegex, nil), nil
 	}
+	m = regexHash.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		return parsePseudonymizeRule(r, "hash", m[0][1], m[0][3])
+	}
+	m = regexKeepOnly.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegex(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		parsedRegexAllow, err := parseRegexExact(r, m[0][2])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("keep_only", parsedRegex, parsedRegexAllow, nil), nil
+	}
+	m = regexLimitPerMinute.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegex(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		m2, err := strconv.Atoi(m[0][2])
+		if err != nil || m2 < 1 {
+			return nil, fmt.Errorf("invalid limit (%s) in rule: %s", m[0][2], r)
+		}
+		if m[0][4] == "" {
+			return NewHttpRule("limit_per_minute", parsedRegex, m2, newRateLimit(nil)), nil
+		}
+		parsedRegexValue, err := parseRegex(r, m[0][4])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("limit_per_minute", parsedRegex, m2, newRateLimit(parsedRegexValue)), nil
+	}
+	m = regexMaskGraphqlVariable.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegex(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("mask_graphql_variable", nil, parsedRegex, nil), nil
+	}
+	m = regexMaskPii.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegex(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		names := []string{"all"}
+		if m[0][3] != "" {
+			names = strings.Split(m[0][3], ",")
+			for i := range names {
+				names[i] = strings.TrimSpace(names[i])
+			}
+		}
+		detectors, invalid := selectPiiDetectors(names)
+		if invalid != "" {
+			return nil, fmt.Errorf("invalid PII detector (%s) in rule: %s", invalid, r)
+		}
+		if m[0][6] == "" {
+			return NewHttpRule("mask_pii", parsedRegex, detectors, nil), nil
+		}
+		parsedString, err := parseString(r, m[0][6])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("mask_pii", parsedRegex, detectors, parsedString), nil
+	}
 	m = regexRemove.FindAllStringSubmatch(r, -1)
 	if m != nil {
 		parsedRegex, err := parseRegex(r, m[0][1])
@@ -330,6 +488,18 @@ func parseRule(r string) (*HttpRule, error) {
 		}
 		return NewHttpRule("remove_if_found", parsedRegex, parsedRegexFind, nil), nil
 	}
+	m = regexRemoveJson.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegex(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		parsedPath, err := parseRulePath(r, m[0][2])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("remove_json", parsedRegex, parsedPath, nil), nil
+	}
 	m = regexRemoveUnless.FindAllStringSubmatch(r, -1)
 	if m != nil {
 		parsedRegex1, err := parseRegex(r, m[0][1])
@@ -370,16 +540,32 @@ func parseRule(r string) (*HttpRule, error) {
 		}
 		return NewHttpRule("replace", parsedRegex, parsedRegexFind, parsedString), nil
 	}
+	m = regexReplaceJson.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegex(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		parsedPath, err := parseRulePath(r, m[0][2])
+		if err != nil {
+			return nil, err
+		}
+		parsedString, err := parseString(r, m[0][3])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("replace_json", parsedRegex, parsedPath, parsedString), nil
+	}
 	m = regexSample.FindAllStringSubmatch(r, -1)
 	if m != nil {
-		m1, err := strconv.Atoi(m[0][1])
+		m1, err := strconv.Atoi(m[0][3])
 		if err != nil {
 			return nil, fmt.Errorf("error parsing sample rule: %s", r)
 		}
 		if m1 < 1 || m1 > 99 {
 			return nil, fmt.Errorf("invalid sample percent: %d", m1)
 		}
-		return NewHttpRule("sample", nil, m1, nil), nil
+		return parseSampleRule(r, m[0], m1)
 	}
 	m = regexSkipCompression.FindAllStringSubmatch(r, -1)
 	if m != nil {
@@ -445,9 +631,82 @@ func parseRule(r string) (*HttpRule, error) {
 		}
 		return NewHttpRule("stop_unless_found", parsedRegex, parsedRegexFind, nil), nil
 	}
+	m = regexTokenize.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		return parsePseudonymizeRule(r, "tokenize", m[0][1], m[0][3])
+	}
+	m = regexTruncate.FindAllStringSubmatch(r, -1)
+	if m != nil {
+		parsedRegex, err := parseRegex(r, m[0][1])
+		if err != nil {
+			return nil, err
+		}
+		m2, err := strconv.Atoi(m[0][2])
+		if err != nil || m2 < 1 {
+			return nil, fmt.Errorf("invalid length (%s) in rule: %s", m[0][2], r)
+		}
+		if m[0][4] == "" {
+			return NewHttpRule("truncate", parsedRegex, m2, defaultTruncateMarker), nil
+		}
+		parsedString, err := parseString(r, m[0][4])
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpRule("truncate", parsedRegex, m2, parsedString), nil
+	}
 	return nil, fmt.Errorf("invalid rule: %s", r)
 }
 
+// Parses hash or tokenize rule, with an optional regex finding the values to pseudonymize.
+func parsePseudonymizeRule(r string, verb string, scope string, find string) (*HttpRule, error) {
+	parsedRegex, err := parseRegex(r, scope)
+	if err != nil {
+		return nil, err
+	}
+	if find == "" {
+		return NewHttpRule(verb, parsedRegex, nil, nil), nil
+	}
+	parsedRegexFind, err := parseRegexFind(r, find)
+	if err != nil {
+		return nil, err
+	}
+	return NewHttpRule(verb, parsedRegex, parsedRegexFind, nil), nil
+}
+
+// Parses sample rule, with an optional scope and regex for values in scope, and an optional key for deterministic sampling.
+func parseSampleRule(r string, m []string, percent int) (*HttpRule, error) {
+	var scope *regexp.Regexp
+	var err error
+	if m[2] != "" {
+		if scope, err = parseRegex(r, m[2]); err != nil {
+			return nil, err
+		}
+	}
+	s := &sampling{}
+	if m[5] != "" {
+		if scope == nil {
+			return nil, fmt.Errorf("sample regex requires a scope in rule: %s", r)
+		}
+		if s.find, err = parseRegex(r, m[5]); err != nil {
+			return nil, err
+		}
+	}
+	if m[7] != "" {
+		if s.key, err = parseRegex(r, m[7]); err != nil {
+			return nil, err
+		}
+	}
+	if m[9] != "" {
+		if s.keyFind, err = parseRegexFind(r, m[9]); err != nil {
+			return nil, err
+		}
+	}
+	if s.find == nil && s.key == nil {
+		return NewHttpRule("sample", scope, percent, nil), nil
+	}
+	return NewHttpRule("sample", scope, percent, s), nil
+}
+
 // Parses regex for matching.
 func parseRegex(r string, regex string) (*regexp.Regexp, error) {
 	s, err := parseString(r, regex)
@@ -471,6 +730,22 @@ func parseRegex(r string, regex string) (*regexp.Regexp, error) {
 	return regexp, nil
 }
 
+// Parses regex for matching whole values, even when it has alternatives, as needed for allowlists.
+func parseRegexExact(r string, regex string) (*regexp.Regexp, error) {
+	s, err := parseString(r, regex)
+	if err != nil {
+		return nil, err
+	}
+	if s == "*" || s == "+" || s == "?" {
+		return nil, fmt.Errorf("invalid regex (%s) in rule: %s", regex, r)
+	}
+	regexp, err := regexp.Compile("^(?:" + strings.TrimSuffix(strings.TrimPrefix(s, "^"), "$") + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex (%s) in rule: %s", regex, r)
+	}
+	return regexp, nil
+}
+
 // Parses regex for finding.
 func parseRegexFind(r string, regex string) (*regexp.Regexp, error) {
 	parsedString, err := parseString(r, regex)
@@ -484,6 +759,15 @@ func parseRegexFind(r string, regex string) (*regexp.Regexp, error) {
 	return regexp, nil
 }
 
+// Parses JSONPath expression.
+func parseRulePath(r string, path string) (*jsonPath, error) {
+	parsedPath, err := parseJsonPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSONPath (%s) in rule: %s", path, r)
+	}
+	return parsedPath, nil
+}
+
 // Parses delimited string expression
 func parseString(r string, expr string) (string, error) {
 	separators := []string{"~", "!", "%", "|", "/"}
@@ -503,34 +787,52 @@ func parseString(r string, expr string) (string, error) {
 	return "", fmt.Errorf("invalid expression (%s) in rule: %s", expr, r)
 }
 
-// Apply current rules to message details.
-func (rules *HttpRules) apply(details [][]string) [][]string {
+// Apply current rules to details of message, returning false if the message was stopped.
+func (rules *HttpRules) apply(message *HttpMessage) bool {
+	details := message.details
+
+	// conditions are tested against details as submitted, before any are removed
+	call := append([][]string(nil), details...)
+
 	// stop rules come first
 	for _, r := range rules.stop {
+		if !r.when(call) {
+			continue
+		}
 		for _, d := range details {
 			if r.scope.FindAllStringSubmatch(d[0], -1) != nil {
-				return nil
+				return false
 			}
 		}
 	}
 	for _, r := range rules.stopIfFound {
+		if !r.when(call) {
+			continue
+		}
 		for _, d := range details {
 			regex := r.param1.(*regexp.Regexp)
 			if r.scope.FindAllStringSubmatch(d[0], -1) != nil && regex.FindAllStringSubmatch(d[1], -1) != nil {
-				return nil
+				return false
 			}
 		}
 	}
 	for _, r := range rules.stopIf {
+		if !r.when(call) {
+			continue
+		}
 		for _, d := range details {
 			regex := r.param1.(*regexp.Regexp)
 			if r.scope.FindAllStringSubmatch(d[0], -1) != nil && regex.FindAllStringSubmatch(d[1], -1) != nil {
-				return nil
+				return false
 			}
 		}
 	}
 	passed := 0
 	for _, r := range rules.stopUnlessFound {
+		if !r.when(call) {
+			passed++
+			continue
+		}
 		for _, d := range details {
 			regex := r.param1.(*regexp.Regexp)
 			if r.scope.FindAllStringSubmatch(d[0], -1) != nil && regex.FindAllStringSubmatch(d[1], -1) != nil {
@@ -539,10 +841,14 @@ func (rules *HttpRules) apply(details [][]string) [][]string {
 		}
 	}
 	if passed != len(rules.stopUnlessFound) {
-		return nil
+		return false
 	}
 	passed = 0
 	for _, r := range rules.stopUnless {
+		if !r.when(call) {
+			passed++
+			continue
+		}
 		for _, d := range details {
 			regex := r.param1.(*regexp.Regexp)
 			if r.scope.FindAllStringSubmatch(d[0], -1) != nil && regex.FindAllStringSubmatch(d[1], -1) != nil {
@@ -551,32 +857,116 @@ func (rules *HttpRules) apply(details [][]string) [][]string {
 		}
 	}
 	if passed != len(rules.stopUnless) {
-		return nil
+		return false
+	}
+
+	// do sampling if configured, where the first sample rule that applies decides, and rules with a scope come first
+	var sample *HttpRule
+	for _, r := range rules.sample {
+		if r.when(call) && r.sampled(call) && (sample == nil || (sample.scope == nil && r.scope != nil)) {
+			sample = r
+		}
+	}
+	if sample != nil && !sample.sample(call) {
+		return false
 	}
 
-	// do sampling if configured
-	if len(rules.sample) == 1 && rand.Intn(100) >= rules.sample[0].param1.(int) {
-		return nil
+	// drop calls over the limits of limit_per_minute rules if configured
+	for _, r := range rules.limitPerMinute {
+		if r.when(call) && !r.allow(call) {
+			return false
+		}
+	}
+
+	// parameters in the query string of request_url are masked by the same rules as request_param details
+	query := parseUrlQuery(details)
+	details = append(details, query.details()...)
+
+	// mask graphql variables by name if configured
+	for _, r := range rules.maskGraphqlVariable {
+		maskGraphqlVariables(details, r.param1.(*regexp.Regexp))
 	}
 
 	// winnow sensitive details based on remove rules if configured
 	for _, r := range rules.remove {
+		if !r.when(call) {
+			continue
+		}
 		details = removeDetailIf(details, [][]interface{}{{true, r.scope}})
 	}
 	for _, r := range rules.removeUnlessFound {
+		if !r.when(call) {
+			continue
+		}
 		details = removeDetailIf(details, [][]interface{}{{true, r.scope}, {false, r.param1.(*regexp.Regexp)}})
 	}
 	for _, r := range rules.removeIfFound {
+		if !r.when(call) {
+			continue
+		}
 		details = removeDetailIf(details, [][]interface{}{{true, r.scope}, {true, r.param1.(*regexp.Regexp)}})
 	}
 	for _, r := range rules.removeUnless {
+		if !r.when(call) {
+			continue
+		}
 		details = removeDetailIf(details, [][]interface{}{{true, r.scope}, {false, r.param1.(*regexp.Regexp)}})
 	}
 	for _, r := range rules.removeIf {
+		if !r.when(call) {
+			continue
+		}
 		details = removeDetailIf(details, [][]interface{}{{true, r.scope}, {true, r.param1.(*regexp.Regexp)}})
 	}
-	if len(details) == 0 {
-		return nil
+
+	// winnow details that aren't allowlisted based on allow_headers and keep_only rules if configured
+	if len(rules.allowHeaders) > 0 || len(rules.keepOnly) > 0 {
+		details = keepOnlyAllowed(details, rules.allowHeaders, rules.keepOnly)
+	}
+	if len(query.without(details)) == 0 {
+		return false
+	}
+
+	// mask values in JSON details based on remove_json and replace_json rules if configured
+	if len(rules.removeJson) > 0 || len(rules.replaceJson) > 0 {
+		for _, d := range details {
+			removes := ruleScoped(rules.removeJson, d[0])
+			replaces := ruleScoped(rules.replaceJson, d[0])
+			if len(removes) > 0 || len(replaces) > 0 {
+				d[1], _ = editJson(d[1], removes, replaces)
+			}
+		}
+	}
+
+	// mask personal data and secrets found by detectors based on mask_pii rules if configured
+	for _, r := range rules.maskPii {
+		var replacement *string
+		if s, ok := r.param2.(string); ok {
+			replacement = &s
+		}
+		for _, d := range details {
+			if r.scope.MatchString(d[0]) {
+				d[1] = maskPii(d[1], r.param1.([]*piiDetector), replacement)
+			}
+		}
+	}
+
+	// pseudonymize sensitive details based on hash and tokenize rules if configured
+	for _, r := range rules.hash {
+		regex, _ := r.param1.(*regexp.Regexp)
+		for _, d := range details {
+			if r.scope.MatchString(d[0]) {
+				d[1] = pseudonymizeValue(d[1], regex, func(v string) string { return hashValue(rules.hashKey, v) })
+			}
+		}
+	}
+	for _, r := range rules.tokenize {
+		regex, _ := r.param1.(*regexp.Regexp)
+		for _, d := range details {
+			if r.scope.MatchString(d[0]) {
+				d[1] = pseudonymizeValue(d[1], regex, func(v string) string { return tokenizeValue(rules.hashKey, v) })
+			}
+		}
 	}
 
 	// mask sensitive details based on replace rules if configured
@@ -588,6 +978,17 @@ func (rules *HttpRules) apply(details [][]string) [][]string {
 		}
 	}
 
+	// shorten long details based on truncate rules if configured, after values are masked
+	for _, r := range rules.truncate {
+		for _, d := range details {
+			if r.scope.MatchString(d[0]) {
+				d[1] = truncateValue(d[1], r.param1.(int), r.param2.(string))
+			}
+		}
+	}
+
+	details = query.mask(details)
+
 	// remove any details with empty values
 	i := 0
 	for _, d := range details {
@@ -598,28 +999,40 @@ func (rules *HttpRules) apply(details [][]string) [][]string {
 	}
 	details = details[:i]
 	if len(details) == 0 {
-		return nil
+		return false
 	}
 
-	return details
+	message.details = details
+	return true
 }
 
 /*
 The following unexported Regexps should be treated as constants
 and remain unchanged throughout package usage
 */
+var regexAllowHeaders *regexp.Regexp = regexp.MustCompile(`^\s*allow_headers\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
 var regexAllowHttpUrl *regexp.Regexp = regexp.MustCompile(`^\s*allow_http_url\s*(#.*)?$`)
 var regexBlankOrComment *regexp.Regexp = regexp.MustCompile(`^\s*([#].*)*$`)
 var regexCopySessionField *regexp.Regexp = regexp.MustCompile(`^\s*copy_session_field\s+([~!%|\/].+[~!%|\/])\s*(#.*)?`)
+var regexHash *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*hash(\s+([~!%|\/].+[~!%|\/]))?\s*(#.*)?$`)
+var regexHeaderDetail *regexp.Regexp = regexp.MustCompile(`^(request|response)_header:(.*)$`)
+var regexKeepOnly *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+?[~!%|\/])\s*keep_only\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
+var regexLimitPerMinute *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+?[~!%|\/])\s*limit_per_minute\s+(\d+)(\s+([~!%|\/].+[~!%|\/]))?\s*(#.*)?$`)
+var regexMaskGraphqlVariable *regexp.Regexp = regexp.MustCompile(`^\s*mask_graphql_variable\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
+var regexMaskPii *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*mask_pii(\s+([a-z_]+(\s*,\s*[a-z_]+)*))?(\s+([~!%|\/].*[~!%|\/]))?\s*(#.*)?$`)
 var regexRemove *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*remove\s*(#.*)?$`)
 var regexRemoveIf *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*remove_if\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
 var regexRemoveIfFound *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*remove_if_found\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
 var regexRemoveUnless *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*remove_unless\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
 var regexRemoveUnlessFound *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*remove_unless_found\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
+var regexRemoveJson *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*remove_json\s+(\$.*?)\s*(#.*)?$`)
 var regexReplace *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*replace[\s]+([~!%|\/].+[~!%|\/]),[\s]+([~!%|\/].*[~!%|\/])\s*(#.*)?$`)
-var regexSample *regexp.Regexp = regexp.MustCompile(`^\s*sample\s+(\d+)\s*(#.*)?$`)
+var regexReplaceJson *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*replace_json\s+(\$.*?),\s+([~!%|\/].*[~!%|\/])\s*(#.*)?$`)
+var regexSample *regexp.Regexp = regexp.MustCompile(`^\s*(([~!%|\/].+?[~!%|\/])\s*)?sample\s+(\d+)(\s+([~!%|\/].+?[~!%|\/]))?(\s+by\s+([~!%|\/].+?[~!%|\/])(\s+([~!%|\/].+?[~!%|\/]))?)?\s*(#.*)?$`)
 var regexSkipCompression *regexp.Regexp = regexp.MustCompile(`^\s*skip_compression\s*(#.*)?$`)
 var regexSkipSubmission *regexp.Regexp = regexp.MustCompile(`^\s*skip_submission\s*(#.*)?$`)
+var regexTokenize *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*tokenize(\s+([~!%|\/].+[~!%|\/]))?\s*(#.*)?$`)
+var regexTruncate *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+?[~!%|\/])\s*truncate\s+(\d+)(\s+([~!%|\/].*[~!%|\/]))?\s*(#.*)?$`)
 var regexStop *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*stop\s*(#.*)?$`)
 var regexStopIf *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*stop_if\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
 var regexStopIfFound *regexp.Regexp = regexp.MustCompile(`^\s*([~!%|\/].+[~!%|\/])\s*stop_if_found\s+([~!%|\/].+[~!%|\/])\s*(#.*)?$`)
@@ -645,6 +1058,58 @@ func ruleFilter(parsedRules []*HttpRule, ruleString string, cond func(string, st
 	return result
 }
 
+// removes headers with names not allowed by any allow_headers rule, and details in scope of keep_only rules
+// with names not allowed by any of them
+func keepOnlyAllowed(details [][]string, allowHeaders []*HttpRule, keepOnly []*HttpRule) [][]string {
+	i := 0
+	for _, d := range details {
+		scoped, allowed := false, false
+		if header := regexHeaderDetail.FindStringSubmatch(d[0]); header != nil {
+			for _, r := range allowHeaders {
+				scoped = true
+				allowed = allowed || r.param1.(*regexp.Regexp).MatchString(header[2])
+			}
+		}
+		for _, r := range keepOnly {
+			if r.scope.MatchString(d[0]) {
+				scoped = true
+				allowed = allowed || r.param1.(*regexp.Regexp).MatchString(d[0])
+			}
+		}
+		if !scoped || allowed {
+			details[i] = d
+			i++
+		}
+	}
+	return details[:i]
+}
+
+// filter a slice of HttpRules to those scoped to the given detail name
+func ruleScoped(parsedRules []*HttpRule, name string) []*HttpRule {
+	var result []*HttpRule
+	for _, rule := range parsedRules {
+		if rule.scope.MatchString(name) {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+// marker appended to values shortened by truncate rules without one, where {length} is the original length
+const defaultTruncateMarker = "... [truncated from {length} characters]"
+
+// returns the first length characters of value and marker, if value is longer
+func truncateValue(value string, length int, marker string) string {
+	if len(value) <= length {
+		return value
+	}
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+	return string(runes[:length]) + strings.ReplaceAll(marker, "{length}", strconv.Itoa(len(runes)))
+}
+
 // https://stackoverflow.com/questions/20545743/delete-entries-from-a-slice-while-iterating-over-it-in-go/20551116
 // remove detail form details slice if all regexp.Regexp are matched from the given slice of *regexp.Regexp
 func removeDetailIf(details [][]string, condRegex [][]interface{}) [][]string {
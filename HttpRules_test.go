@@ -5,6 +5,7 @@ package logger
 import (
 	"fmt"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -229,6 +230,14 @@ func parseOk(t *testing.T, line string, verb string,
 		assert.Nil(t, param1)
 	} else if isRegexp {
 		assert.Equal(t, ruleParam1.(*regexp.Regexp).String(), param1)
+	} else if path, isPath := ruleParam1.(*jsonPath); isPath {
+		assert.Equal(t, path.String(), param1)
+	} else if detectors, isDetectors := ruleParam1.([]*piiDetector); isDetectors {
+		names := make([]string, len(detectors))
+		for i, detector := range detectors {
+			names[i] = detector.name
+		}
+		assert.Equal(t, strings.Join(names, ","), param1)
 	} else {
 		assert.Equal(t, ruleParam1, param1)
 	}
@@ -371,6 +380,189 @@ func TestParsesCopySessionFieldRules(t *testing.T) {
 	parseOk(t, "copy_session_field /A\\/B\\/C/", "copy_session_field", "", "^A/B/C$", nil)
 }
 
+func TestParsesHashRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ hash /1/, /2/")
+	parseFail(t, "/.*/ hash /1/ /2/")
+
+	// with missing or invalid params
+	parseFail(t, "hash")
+	parseFail(t, "hash /1/")
+	parseFail(t, "/.*/ hash 1")
+	parseFail(t, "/.*/ hash/1/")
+	parseFail(t, "/.*/ hash /(.*/")
+
+	// with valid regexes
+	parseOk(t, "/request_header:authorization/ hash", "hash", "^request_header:authorization$", nil, nil)
+	parseOk(t, "!request_body! hash !\\d{3}-\\d{2}-\\d{4}! # bleep", "hash", "^request_body$", "\\d{3}-\\d{2}-\\d{4}", nil)
+	parseOk(t, "|.*| hash |[a-z]+@[a-z.]+|", "hash", "^.*$", "[a-z]+@[a-z.]+", nil)
+}
+
+func TestParsesTokenizeRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ tokenize /1/, /2/")
+
+	// with missing or invalid params
+	parseFail(t, "tokenize /1/")
+	parseFail(t, "/.*/ tokenize 1")
+	parseFail(t, "/.*/ tokenize /(.*/")
+
+	// with valid regexes
+	parseOk(t, "/request_param:card/ tokenize", "tokenize", "^request_param:card$", nil, nil)
+	parseOk(t, "/response_body/ tokenize /\\d{16}/ # bleep", "tokenize", "^response_body$", "\\d{16}", nil)
+}
+
+func TestParsesAllowHeadersRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "allow_headers /1/, /2/")
+	parseFail(t, "/.*/ allow_headers /1/")
+
+	// with missing or invalid params
+	parseFail(t, "allow_headers")
+	parseFail(t, "allow_headers accept")
+	parseFail(t, "allow_headers /*/")
+	parseFail(t, "allow_headers /(.*/")
+
+	// with valid regexes
+	parseOk(t, "allow_headers /accept|content-type/", "allow_headers", "", "^(?:accept|content-type)$", nil)
+	parseOk(t, "allow_headers !^user-agent$! # bleep", "allow_headers", "", "^(?:user-agent)$", nil)
+}
+
+func TestParsesKeepOnlyRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ keep_only /1/, /2/")
+
+	// with missing or invalid params
+	parseFail(t, "keep_only /1/")
+	parseFail(t, "/.*/ keep_only")
+	parseFail(t, "/.*/ keep_only /(.*/")
+
+	// with valid regexes
+	parseOk(t, "/request_param:.*/ keep_only /request_param:(page|size)/", "keep_only", "^request_param:.*$",
+		"^(?:request_param:(page|size))$", nil)
+	parseOk(t, "|session_field:.*| keep_only |session_field:id| # bleep", "keep_only", "^session_field:.*$",
+		"^(?:session_field:id)$", nil)
+}
+
+func TestParsesLimitPerMinuteRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ limit_per_minute 10 /1/, /2/")
+
+	// with missing or invalid params
+	parseFail(t, "limit_per_minute 10")
+	parseFail(t, "/.*/ limit_per_minute")
+	parseFail(t, "/.*/ limit_per_minute 0")
+	parseFail(t, "/.*/ limit_per_minute ten")
+	parseFail(t, "/.*/ limit_per_minute 10 /(.*/")
+
+	// with valid params
+	parseOk(t, "/request_url/ limit_per_minute 10", "limit_per_minute", "^request_url$", 10, newRateLimit(nil))
+	parseOk(t, "/request_url/ limit_per_minute 10 /.*healthz.*/ # bleep", "limit_per_minute", "^request_url$", 10,
+		newRateLimit(regexp.MustCompile("^.*healthz.*$")))
+}
+
+func TestParsesTruncateRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ truncate 10 /1/, /2/")
+
+	// with missing or invalid params
+	parseFail(t, "truncate 10")
+	parseFail(t, "/.*/ truncate")
+	parseFail(t, "/.*/ truncate 0")
+	parseFail(t, "/.*/ truncate -1")
+	parseFail(t, "/.*/ truncate 10 /...")
+
+	// with valid params
+	parseOk(t, "/response_body/ truncate 4096", "truncate", "^response_body$", 4096, defaultTruncateMarker)
+	parseOk(t, "/response_body/ truncate 10 /.../ # bleep", "truncate", "^response_body$", 10, "...")
+	parseOk(t, "/response_body/ truncate 10 !! # bleep", "truncate", "^response_body$", 10, "")
+	parseOk(t, "/request_header:.*/ truncate 10 | ({length})|", "truncate", "^request_header:.*$", 10, " ({length})")
+}
+
+func TestTruncatesValues(t *testing.T) {
+	assert.Equal(t, "abc", truncateValue("abc", 3, "..."))
+	assert.Equal(t, "ab...", truncateValue("abc", 2, "..."))
+	assert.Equal(t, "ab (3)", truncateValue("abc", 2, " ({length})"))
+	assert.Equal(t, "日本語", truncateValue("日本語", 3, "..."))
+	assert.Equal(t, "日本... [truncated from 3 characters]", truncateValue("日本語", 2, defaultTruncateMarker))
+}
+
+func TestParsesMaskPiiRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ mask_pii card /1/, /2/")
+
+	// with missing or invalid params
+	parseFail(t, "mask_pii")
+	parseFail(t, "mask_pii card")
+	parseFail(t, "/.*/ mask_pii passport")
+	parseFail(t, "/.*/ mask_pii card,")
+	parseFail(t, "/.*/ mask_pii card /x")
+
+	// with valid detectors and replacements
+	parseOk(t, "/response_body/ mask_pii", "mask_pii", "^response_body$", "jwt,bearer,aws_key,iban,card,ssn", nil)
+	parseOk(t, "/response_body/ mask_pii card", "mask_pii", "^response_body$", "card", nil)
+	parseOk(t, "/request_body/ mask_pii ssn, card , iban # bleep", "mask_pii", "^request_body$", "iban,card,ssn", nil)
+	parseOk(t, "/request_header:.*/ mask_pii credentials", "mask_pii", "^request_header:.*$", "jwt,bearer,aws_key", nil)
+	parseOk(t, "/request_body/ mask_pii financial, ssn", "mask_pii", "^request_body$", "iban,card,ssn", nil)
+	parseOk(t, "/request_body/ mask_pii card /****/", "mask_pii", "^request_body$", "card", "****")
+	parseOk(t, "/request_body/ mask_pii !xxx!", "mask_pii", "^request_body$", "jwt,bearer,aws_key,iban,card,ssn", "xxx")
+}
+
+func TestParsesMaskGraphqlVariableRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ mask_graphql_variable /1/")
+	parseFail(t, "mask_graphql_variable /1/, /2/")
+	parseFail(t, "mask_graphql_variable /1/ /2/")
+
+	// with missing or invalid params
+	parseFail(t, "mask_graphql_variable")
+	parseFail(t, "mask_graphql_variable password")
+	parseFail(t, "mask_graphql_variable //")
+	parseFail(t, "mask_graphql_variable /*/")
+	parseFail(t, "mask_graphql_variable /(.*/")
+
+	// with valid regexes
+	parseOk(t, "mask_graphql_variable /password/", "mask_graphql_variable", "", "^password$", nil)
+	parseOk(t, "mask_graphql_variable !password|token! # bleep", "mask_graphql_variable", "", "^password|token$", nil)
+	parseOk(t, "mask_graphql_variable |card\\|number|", "mask_graphql_variable", "", "^card|number$", nil)
+}
+
+func TestParsesRemoveJsonRules(t *testing.T) {
+	// with extra params
+	parseFail(t, "/.*/ remove_json $.a, /1/")
+	parseFail(t, "/.*/ remove_json $.a $.b")
+
+	// with missing or invalid params
+	parseFail(t, "remove_json $.a")
+	parseFail(t, "/.*/ remove_json")
+	parseFail(t, "/.*/ remove_json a.b")
+	parseFail(t, "/.*/ remove_json $.")
+	parseFail(t, "/.*/ remove_json $..")
+	parseFail(t, "/.*/ remove_json $[a]")
+	parseFail(t, "/.*/ remove_json $['a'")
+	parseFail(t, "/*/ remove_json $.a")
+
+	// with valid paths
+	parseOk(t, "/response_body/ remove_json $.card.number", "remove_json", "^response_body$", "$.card.number", nil)
+	parseOk(t, "!.*_body! remove_json $..ssn # bleep", "remove_json", "^.*_body$", "$..ssn", nil)
+	parseOk(t, "/request_body/ remove_json $.users[*]['home address']", "remove_json", "^request_body$", "$.users[*]['home address']", nil)
+	parseOk(t, "/request_body/ remove_json $", "remove_json", "^request_body$", "$", nil)
+}
+
+func TestParsesReplaceJsonRules(t *testing.T) {
+	// with missing or invalid params
+	parseFail(t, "/.*/ replace_json $.a")
+	parseFail(t, "/.*/ replace_json $.a /1/")
+	parseFail(t, "/.*/ replace_json /1/, $.a")
+	parseFail(t, "/.*/ replace_json $.a[x], /1/")
+	parseFail(t, "replace_json $.a, /1/")
+
+	// with valid paths and replacements
+	parseOk(t, "/response_body/ replace_json $.user.ssn, /xxx/", "replace_json", "^response_body$", "$.user.ssn", "xxx")
+	parseOk(t, "|request_body| replace_json $.cards[-1].number, !! # bleep", "replace_json", "^request_body$", "$.cards[-1].number", "")
+	parseOk(t, "/request_body/ replace_json $..*, %\\%%", "replace_json", "^request_body$", "$..*", "%")
+}
+
 func TestParsesRemoveRules(t *testing.T) {
 	// with extra params
 	parseFail(t, "|.*| remove %1%")
@@ -679,6 +871,60 @@ func TestParsesSampleRules(t *testing.T) {
 	parseFail(t, "sample blue # bleep")
 	parseFail(t, "sample //")
 	parseFail(t, "sample /42/")
+	parseFail(t, "sample 10 /x/")
+	parseFail(t, "/request_url/ sample 10 /x/ /y/")
+	parseFail(t, "sample 10 by")
+	parseFail(t, "sample 10 by /(/")
+	parseFail(t, "sample 10 by /trace_id/ /(/")
+
+	// with valid params
+	parseOk(t, "sample 10", "sample", "", 10, nil)
+	parseOk(t, "sample 10 # bleep", "sample", "", 10, nil)
+	parseOk(t, "/request_url/ sample 5", "sample", "^request_url$", 5, nil)
+
+	rule, _ := parseRule("/request_url/ sample 5 /.*\\/api\\/.*/ by /session_field:user_id/")
+	assert.Equal(t, "^request_url$", rule.scope.String())
+	assert.Equal(t, 5, rule.param1)
+	assert.Equal(t, "^.*/api/.*$", rule.param2.(*sampling).find.String())
+	assert.Equal(t, "^session_field:user_id$", rule.param2.(*sampling).key.String())
+	assert.Nil(t, rule.param2.(*sampling).keyFind)
+
+	rule, _ = parseRule("sample 20 by /request_header:cookie/ /session=([^;]+)/ # bleep")
+	assert.Nil(t, rule.scope)
+	assert.Nil(t, rule.param2.(*sampling).find)
+	assert.Equal(t, "^request_header:cookie$", rule.param2.(*sampling).key.String())
+	assert.Equal(t, "session=([^;]+)", rule.param2.(*sampling).keyFind.String())
+}
+
+func TestParsesRuleConditions(t *testing.T) {
+	// with invalid or unsupported conditions
+	parseFail(t, "sample 10 when response_code")
+	parseFail(t, "sample 10 when response_code 2xx or 3xx")
+	parseFail(t, "sample 10 when response_code 6xx")
+	parseFail(t, "sample 10 when response_code 599-500")
+	parseFail(t, "sample 10 when interval = 100")
+	parseFail(t, "sample 10 when request_method GET and")
+	parseFail(t, "/request_body/ replace /x/, /y/ when interval > 100")
+	parseFail(t, "copy_session_field /.*/ when request_method GET")
+
+	// with valid conditions
+	parseOk(t, "sample 10 when response_code 2xx", "sample", "", 10, nil)
+	parseOk(t, "/request_body/ remove when interval < 2000 # bleep", "remove", "^request_body$", nil, nil)
+	parseOk(t, "/request_body/ remove_if /when interval > 5/ when request_method GET", "remove_if", "^request_body$", "^when interval > 5$", nil)
+	parseOk(t, "/request_body/ remove # not when it's slow", "remove", "^request_body$", nil, nil)
+
+	call := func(code string, method string, interval string) [][]string {
+		return [][]string{{"request_method", method}, {"response_code", code}, {"interval", interval}}
+	}
+	rule, _ := parseRule("/.*/ stop when response_code 404,500-503,2xx and request_method post,put and interval >= 100")
+	assert.True(t, rule.when(call("200", "POST", "100")))
+	assert.True(t, rule.when(call("502", "PUT", "2500")))
+	assert.False(t, rule.when(call("504", "POST", "100")))
+	assert.False(t, rule.when(call("404", "GET", "100")))
+	assert.False(t, rule.when(call("404", "POST", "99")))
+	assert.False(t, rule.when([][]string{{"request_method", "POST"}, {"interval", "100"}}))
+	rule, _ = parseRule("/.*/ stop")
+	assert.True(t, rule.when(nil))
 }
 
 func TestParsesSkipCompressionRules(t *testing.T) {
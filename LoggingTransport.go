@@ -0,0 +1,248 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LoggingTransport defines an http.RoundTripper used to log client side API calls made through any http.Client,
+// including clients created by third-party SDKs that accept a custom transport.
+type LoggingTransport struct {
+	Transport  http.RoundTripper
+	HttpLogger *HttpLogger
+
+	//TraceTimings enables net/http/httptrace instrumentation, logging DNS, connect, TLS handshake,
+	//time-to-first-byte and body transfer durations as additional details.
+	TraceTimings bool
+}
+
+// NewLoggingTransportOptions() takes 2 arguments; the http.RoundTripper to wrap and a logger.Options struct, and returns 2 objects;
+// a pointer to an instance of a LoggingTransport struct and an error.
+// If the given transport is nil, http.DefaultTransport is used.
+// If there is no error, the error value returned will be nil.
+func NewLoggingTransportOptions(transport http.RoundTripper, options Options) (*LoggingTransport, error) {
+	// unlike older loggers, which lowercase them, this logger always keeps custom field values verbatim
+	options.PreserveCustomFieldValues = true
+	HttpLogger, err := NewHttpLogger(options)
+	if err != nil {
+		return nil, err
+	}
+	return &LoggingTransport{
+		Transport:  transport,
+		HttpLogger: HttpLogger,
+	}, nil
+}
+
+// NewLoggingTransport() takes 1 argument, the http.RoundTripper to wrap, and returns 2 objects;
+// a pointer to an instance of a LoggingTransport struct and an error.
+// The LoggingTransport returned by this function has the default options applied.
+// If there is no error, the error value returned will be nil.
+func NewLoggingTransport(transport http.RoundTripper) (*LoggingTransport, error) {
+	return NewLoggingTransportOptions(transport, Options{})
+}
+
+func (t *LoggingTransport) Logger() *HttpLogger {
+	return t.HttpLogger
+}
+
+func (t *LoggingTransport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// RoundTrip(req *http.Request) executes a single HTTP transaction using the wrapped transport and logs it.
+// Request and response bodies are captured as they are read by the transport and the caller, so they are never consumed
+// on the caller's behalf. The message is sent once the response body has been read to the end or closed.
+func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return logRoundTrip(t.HttpLogger, req, t.TraceTimings, false, t.transport().RoundTrip)
+}
+
+// logRoundTrip(l *HttpLogger, req *http.Request, trace bool, eager bool, roundTrip) executes roundTrip for req and uses logger l to log the exchange,
+// or the error if the call failed. When trace is true, the phases of the call are recorded as additional interval details.
+// When eager is true, up to LIMIT bytes of the response body are read before returning, so the exchange is logged when the call
+// completes, and the caller reads the same body; otherwise it is logged once the caller has read the response body to the end or closed it.
+func logRoundTrip(logger *HttpLogger, req *http.Request, trace bool, eager bool, roundTrip func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if logger == nil || !logger.Enabled() {
+		return roundTrip(req)
+	}
+
+	var reqCapture *captureReadCloser
+	outReq := req
+	if req.Body != nil && req.Body != http.NoBody {
+		reqCapture = newRequestCaptureReadCloser(req.Body, req.Header)
+		outReq = req.Clone(req.Context())
+		outReq.Body = reqCapture
+	}
+
+	// copy of the request carrying whatever body the transport has read so far
+	loggingRequest := func(base *http.Request) *http.Request {
+		loggingReq := base.Clone(base.Context())
+		if reqCapture != nil {
+			loggingReq.Body = reqCapture.Logged()
+		} else {
+			loggingReq.Body = nil
+		}
+		return loggingReq
+	}
+
+	now := time.Now()
+
+	var timings *clientTimings
+	if trace {
+		outReq, timings = newClientTimings(outReq, now)
+	}
+
+	// send the message once the call has completed
+	send := func(message *HttpMessage, loggingReq *http.Request, loggingResp *http.Response) {
+		done := time.Now()
+		if timings != nil {
+			timings.appendDetails(message, done)
+		}
+		sendHttpMessageDetails(logger, message, loggingReq, loggingResp, now.UnixNano()/int64(time.Millisecond), done.Sub(now).Milliseconds(), nil)
+	}
+
+	resp, err := roundTrip(outReq)
+	if err != nil {
+		loggingReq := loggingRequest(req)
+		send(buildHttpErrorMessage(loggingReq, err), loggingReq, nil)
+		return resp, err
+	}
+
+	sendResponse := func(respBody []byte) {
+		if !logger.Enabled() {
+			return
+		}
+
+		base := req
+		if resp.Request != nil {
+			base = resp.Request
+		}
+		loggingReq := loggingRequest(base)
+
+		loggingResp := &http.Response{
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Proto:      resp.Proto,
+			ProtoMajor: resp.ProtoMajor,
+			ProtoMinor: resp.ProtoMinor,
+			Header:     resp.Header.Clone(),
+			Request:    loggingReq,
+		}
+		if respBody != nil {
+			loggingResp.Body = io.NopCloser(bytes.NewReader(respBody))
+		}
+
+		send(buildHttpMessage(loggingReq, loggingResp), loggingReq, loggingResp)
+	}
+
+	if resp.Body == nil || resp.Body == http.NoBody {
+		sendResponse(nil)
+		return resp, nil
+	}
+
+	if eager {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, int64(LIMIT)))
+		resp.Body = &replayReadCloser{Reader: io.MultiReader(bytes.NewReader(respBody), resp.Body), Closer: resp.Body}
+		sendResponse(respBody)
+		return resp, nil
+	}
+
+	var respCapture *captureReadCloser
+	respCapture = newCaptureReadCloser(resp.Body, func() {
+		sendResponse(respCapture.Bytes())
+	})
+	resp.Body = respCapture
+
+	return resp, nil
+}
+
+// captureReadCloser copies up to LIMIT bytes of everything read through it, without altering what the reader sees.
+// onDone is called once, when the wrapped reader reaches EOF or is closed, whichever happens first.
+type captureReadCloser struct {
+	io.ReadCloser
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	multipart *multipartSummary
+	onDone    func()
+	done      sync.Once
+}
+
+func newCaptureReadCloser(rc io.ReadCloser, onDone func()) *captureReadCloser {
+	return &captureReadCloser{
+		ReadCloser: rc,
+		onDone:     onDone,
+	}
+}
+
+// newRequestCaptureReadCloser captures a request body like newCaptureReadCloser, except for multipart/form-data bodies,
+// which are summarized as they are read instead.
+func newRequestCaptureReadCloser(rc io.ReadCloser, header http.Header) *captureReadCloser {
+	c := newCaptureReadCloser(rc, nil)
+	if boundary := multipartBoundary(header); boundary != "" {
+		c.multipart = newMultipartSummary(boundary)
+	}
+	return c
+}
+
+func (c *captureReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 && c.multipart != nil {
+		_, _ = c.multipart.Write(p[:n])
+	} else if n > 0 {
+		c.mu.Lock()
+		if remaining := LIMIT - c.buf.Len(); remaining > 0 {
+			if n < remaining {
+				remaining = n
+			}
+			c.buf.Write(p[:remaining])
+		}
+		c.mu.Unlock()
+	}
+	if err == io.EOF {
+		c.finish()
+	}
+	return n, err
+}
+
+func (c *captureReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.finish()
+	return err
+}
+
+func (c *captureReadCloser) finish() {
+	if c.multipart != nil {
+		c.multipart.finish()
+	}
+	if c.onDone != nil {
+		c.done.Do(c.onDone)
+	}
+}
+
+// Bytes() returns a copy of the bytes captured so far.
+func (c *captureReadCloser) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte{}, c.buf.Bytes()...)
+}
+
+// Logged() returns the body to log, with the bytes captured so far or the summary of a multipart/form-data body.
+func (c *captureReadCloser) Logged() io.ReadCloser {
+	if c.multipart != nil {
+		return c.multipart
+	}
+	return io.NopCloser(bytes.NewReader(c.Bytes()))
+}
+
+// replayReadCloser reads bytes already read from a body, followed by the rest of the body, and closes the body.
+type replayReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,142 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(201)
+		_, _ = w.Write([]byte("echo: " + string(body)))
+	}))
+}
+
+func TestLoggingTransportLogsExchange(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	transport, err := NewLoggingTransportOptions(nil, Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	assert.Nil(t, err)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Post(server.URL+"/path?foo=bar", "text/plain", bytes.NewBufferString("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(transport.Logger().Queue()), "message sent before body was read")
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Body.Close())
+	assert.Equal(t, "echo: hello", string(body), "response body consumed by transport")
+
+	queue := transport.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.True(t, parseable(queue[0]))
+	assert.Contains(t, queue[0], "[\"request_method\",\"POST\"]")
+	assert.Contains(t, queue[0], "[\"request_url\",\""+server.URL+"/path?foo=bar\"]")
+	assert.Contains(t, queue[0], "[\"request_body\",\"hello\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"201\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"echo: hello\"]")
+}
+
+func TestLoggingTransportLogsOnClose(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	transport, _ := NewLoggingTransportOptions(http.DefaultTransport, Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	partial := make([]byte, 4)
+	_, _ = io.ReadFull(resp.Body, partial)
+	assert.Nil(t, resp.Body.Close())
+	assert.Nil(t, resp.Body.Close())
+
+	queue := transport.Logger().Queue()
+	assert.Equal(t, 1, len(queue), "message not sent exactly once")
+	assert.True(t, strings.Contains(queue[0], "[\"response_body\",\"echo\"]"))
+	assert.False(t, strings.Contains(queue[0], "request_body"))
+}
+
+func TestLoggingTransportSkipsWhenDisabled(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	transport, _ := NewLoggingTransportOptions(nil, Options{
+		Queue:   make([]string, 0),
+		Enabled: false,
+	})
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, 0, len(transport.Logger().Queue()))
+}
+
+func TestLoggingTransportLogsFailedCalls(t *testing.T) {
+	server := newTestServer()
+	url := server.URL
+	server.Close()
+
+	transport, _ := NewLoggingTransportOptions(nil, Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Post(url, "text/plain", bytes.NewBufferString("hello"))
+	assert.NotNil(t, err)
+
+	queue := transport.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"response_code\",\"502\"]")
+	assert.Contains(t, queue[0], "[\"response_error\",\"connection_refused: ")
+}
+
+func TestLoggingTransportPreservesCustomFieldValues(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	transport, _ := NewLoggingTransportOptions(nil, Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	client := &http.Client{Transport: transport}
+
+	ctx := WithCustomFields(context.Background())
+	SetCustomField(ctx, "Order-ID", "AbC123")
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	queue := transport.Logger().Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"custom_field:order-id\",\"AbC123\"]")
+}
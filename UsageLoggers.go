@@ -83,6 +83,13 @@ func (uLogger *UsageLoggers) UrlByDefault() string {
 	return url
 }
 
+/**
+* Returns key to use by default for hash and tokenize rules.
+ */
+func (uLogger *UsageLoggers) HashKeyByDefault() string {
+	return getEnvVar("USAGE_LOGGERS_HASH_KEY")
+}
+
 /**
 * Returns additional configuration for the base logger
  */
@@ -0,0 +1,123 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// returns the networks of trusted proxies, given as CIDRs or single addresses, or an error if any isn't valid
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", proxy)
+			}
+			if ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// returns the host of a remote address, with or without a port, and without brackets around IPv6 addresses
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// returns the IP address of a forwarding header node, which may have a port or be quoted as in Forwarded headers,
+// or nil if it's unknown or obfuscated
+func parseNodeIp(node string) net.IP {
+	node = strings.Trim(strings.TrimSpace(node), "\"")
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	return net.ParseIP(remoteHost(node))
+}
+
+// returns the for= nodes of the Forwarded headers of req, in order
+func forwardedNodes(req *http.Request) []string {
+	var nodes []string
+	for _, value := range req.Header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, node, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					nodes = append(nodes, node)
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+// returns the X-Forwarded-For nodes of req, in order
+func xForwardedForNodes(req *http.Request) []string {
+	var nodes []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		for _, node := range strings.Split(value, ",") {
+			if node = strings.TrimSpace(node); node != "" {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes
+}
+
+func (logger *HttpLogger) isTrustedProxy(ip net.IP) bool {
+	for _, network := range logger.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the IP address of the client that sent req, or "" if unknown. Forwarding headers are only used if they were
+// set by trusted proxies: the client is the closest node not trusted, walking back the chain from the remote address.
+func (logger *HttpLogger) clientIp(req *http.Request) string {
+	client := parseNodeIp(req.RemoteAddr)
+	if client == nil {
+		return ""
+	}
+	if !logger.isTrustedProxy(client) {
+		return client.String()
+	}
+
+	nodes := forwardedNodes(req)
+	if len(nodes) == 0 {
+		nodes = xForwardedForNodes(req)
+	}
+	if len(nodes) == 0 {
+		nodes = req.Header.Values("X-Real-Ip")
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		ip := parseNodeIp(nodes[i])
+		if ip == nil {
+			// the chain can't be followed past unknown or obfuscated nodes
+			break
+		}
+		client = ip
+		if !logger.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client.String()
+}
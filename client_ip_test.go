@@ -0,0 +1,75 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvesClientIps(t *testing.T) {
+	logger, err := NewHttpLogger(Options{TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"}})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		remoteAddr string
+		header     http.Header
+		expected   string
+	}{
+		{"203.0.113.7:52000", nil, "203.0.113.7"},
+		{"[2001:db8:cafe::17]:4711", nil, "2001:db8:cafe::17"},
+		{"2001:db8:cafe::17", nil, "2001:db8:cafe::17"},
+		{"not an address", nil, ""},
+		// headers of untrusted clients are ignored
+		{"203.0.113.7:52000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"10.0.0.2:80", http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.9, 10.0.0.3"}}, "203.0.113.9"},
+		{"10.0.0.2:80", http.Header{"X-Forwarded-For": {"198.51.100.1", "10.0.0.3"}}, "198.51.100.1"},
+		{"10.0.0.2:80", http.Header{"X-Forwarded-For": {"10.0.0.4, 10.0.0.3"}}, "10.0.0.4"},
+		{"[2001:db8::1]:80", http.Header{"X-Forwarded-For": {"[2001:db8:cafe::17]:4711"}}, "2001:db8:cafe::17"},
+		{"10.0.0.2:80", http.Header{"Forwarded": {`for=198.51.100.1;proto=https, for="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"10.0.0.2:80", http.Header{"Forwarded": {"for=198.51.100.1, for=unknown"}, "X-Forwarded-For": {"203.0.113.9"}}, "10.0.0.2"},
+		{"10.0.0.2:80", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"10.0.0.2:80", nil, "10.0.0.2"},
+	}
+	for _, test := range tests {
+		req := &http.Request{RemoteAddr: test.remoteAddr, Header: test.header}
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		assert.Equal(t, test.expected, logger.clientIp(req), test.remoteAddr, test.header)
+	}
+
+	_, err = NewHttpLogger(Options{TrustedProxies: []string{"10.0.0.0/33"}})
+	assert.Equal(t, "invalid trusted proxy: 10.0.0.0/33", err.Error())
+	_, err = NewHttpLogger(Options{TrustedProxies: []string{"proxy.local"}})
+	assert.Equal(t, "invalid trusted proxy: proxy.local", err.Error())
+}
+
+func TestLogsClientIps(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{
+		Queue:          make([]string, 0),
+		Rules:          "include debug",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+
+	req := MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.RemoteAddr = "[2001:db8:cafe::17]:4711"
+	resp := MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+
+	req = MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.RemoteAddr = "10.0.0.2:80"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	resp = MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+
+	queue := logger.Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.Contains(t, queue[0], "[\"request_header:x-forwarded-for\",\"2001:db8:cafe::17\"]")
+	assert.Contains(t, queue[0], "[\"request_client_ip\",\"2001:db8:cafe::17\"]")
+	assert.Contains(t, queue[1], "[\"request_header:x-forwarded-for\",\"203.0.113.9\"]")
+	assert.NotContains(t, queue[1], "10.0.0.2")
+	assert.Contains(t, queue[1], "[\"request_client_ip\",\"203.0.113.9\"]")
+}
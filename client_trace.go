@@ -0,0 +1,80 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// clientTimings records the phases of an outgoing call using net/http/httptrace.
+type clientTimings struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+}
+
+// returns a copy of req instrumented to record its timings
+func newClientTimings(req *http.Request, start time.Time) (*http.Request, *clientTimings) {
+	timings := &clientTimings{start: start}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			timings.mark(&timings.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			timings.mark(&timings.dnsDone)
+		},
+		ConnectStart: func(string, string) {
+			timings.mark(&timings.connectStart)
+		},
+		ConnectDone: func(string, string, error) {
+			timings.mark(&timings.connectDone)
+		},
+		TLSHandshakeStart: func() {
+			timings.mark(&timings.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			timings.mark(&timings.tlsDone)
+		},
+		GotFirstResponseByte: func() {
+			timings.mark(&timings.firstByte)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), timings
+}
+
+// records the first occurrence of an event, as happy eyeballs may dial more than once
+func (timings *clientTimings) mark(t *time.Time) {
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+	if t.IsZero() {
+		*t = time.Now()
+	}
+}
+
+// adds durations in milliseconds for every phase that took place, given the time the call completed
+func (timings *clientTimings) appendDetails(message *HttpMessage, done time.Time) {
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+
+	appendInterval := func(name string, start time.Time, end time.Time) {
+		if !start.IsZero() && !end.IsZero() {
+			message.Add(name, strconv.FormatInt(end.Sub(start).Milliseconds(), 10))
+		}
+	}
+	appendInterval("interval_dns", timings.dnsStart, timings.dnsDone)
+	appendInterval("interval_connect", timings.connectStart, timings.connectDone)
+	appendInterval("interval_tls", timings.tlsStart, timings.tlsDone)
+	appendInterval("interval_ttfb", timings.start, timings.firstByte)
+	appendInterval("interval_transfer", timings.firstByte, done)
+}
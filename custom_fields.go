@@ -0,0 +1,87 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// context key used to attach custom fields to the call being handled
+type customFieldsKey struct{}
+
+// customFieldSet holds the custom fields of a single call, set from any goroutine handling it
+type customFieldSet struct {
+	mu     sync.Mutex
+	fields map[string]string
+}
+
+// WithCustomFields(ctx context.Context) returns a copy of ctx that can carry custom fields set with SetCustomField,
+// or ctx itself if it already can. Loggers do this for every call they handle, so handlers only need SetCustomField.
+func WithCustomFields(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(customFieldsKey{}).(*customFieldSet); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, customFieldsKey{}, &customFieldSet{fields: map[string]string{}})
+}
+
+// SetCustomField(ctx context.Context, k string, v string) attaches custom field k with value v to the call handled with ctx,
+// such as a tenant or user ID, to be logged along with it. It returns false if ctx can't carry custom fields,
+// because it was neither returned by WithCustomFields nor derived from a context that was.
+func SetCustomField(ctx context.Context, key string, value string) bool {
+	set, ok := ctx.Value(customFieldsKey{}).(*customFieldSet)
+	if !ok {
+		return false
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.fields[key] = value
+	return true
+}
+
+// CustomFields(ctx context.Context) returns a copy of the custom fields attached to ctx, or nil if there are none.
+func CustomFields(ctx context.Context) map[string]string {
+	set, ok := ctx.Value(customFieldsKey{}).(*customFieldSet)
+	if !ok {
+		return nil
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if len(set.fields) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(set.fields))
+	for key, value := range set.fields {
+		fields[key] = value
+	}
+	return fields
+}
+
+// returns the custom fields attached to ctx, overridden by those given explicitly
+func mergeCustomFields(ctx context.Context, customFields map[string]string) map[string]string {
+	fields := CustomFields(ctx)
+	if fields == nil {
+		return customFields
+	}
+	for key, value := range customFields {
+		fields[key] = value
+	}
+	return fields
+}
+
+// returns the detail name for custom field key, lowercased and with characters other than letters, digits, '_', '-' and '.'
+// replaced by '_', so that keys can't break out of the custom_field: prefix or collide with other details; or false if key is blank
+func customFieldName(key string) (string, bool) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "" {
+		return "", false
+	}
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, key)
+	return "custom_field:" + name, true
+}
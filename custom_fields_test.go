@@ -0,0 +1,144 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetsCustomFieldsInContext(t *testing.T) {
+	assert.False(t, SetCustomField(context.Background(), "tenant", "acme"))
+	assert.Nil(t, CustomFields(context.Background()))
+
+	ctx := WithCustomFields(context.Background())
+	assert.Equal(t, ctx, WithCustomFields(ctx))
+	assert.Nil(t, CustomFields(ctx))
+
+	derived, cancel := context.WithCancel(ctx)
+	defer cancel()
+	assert.True(t, SetCustomField(derived, "tenant", "acme"))
+	fields := CustomFields(ctx)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, fields)
+
+	fields["user"] = "1"
+	assert.Equal(t, 1, len(CustomFields(ctx)), "fields changed through copy")
+}
+
+func TestLogsCustomFieldsFromContext(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{
+		Queue: make([]string, 0),
+		Rules: "include debug",
+	})
+
+	req := MockGetRequestWithBody([]byte("{}"), "application/json")
+	ctx := WithCustomFields(req.Context())
+	SetCustomField(ctx, "tenant", "acme")
+	SetCustomField(ctx, "user", "1")
+	req = *req.WithContext(ctx)
+	resp := MockGetPlainTextResponse(&req)
+
+	SendHttpMessage(logger, &resp, &req, 0, 0, map[string]string{"user": "2"})
+	queue := logger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"custom_field:tenant\",\"acme\"]")
+	assert.Contains(t, queue[0], "[\"custom_field:user\",\"2\"]")
+	assert.NotContains(t, queue[0], "[\"custom_field:user\",\"1\"]")
+}
+
+func TestUsesEnrichers(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{
+		Queue: make([]string, 0),
+		Rules: "include debug\n/cache_status/ replace /miss/, /cold/",
+	})
+
+	logger.AddEnricher(func(message *HttpMessage, req *http.Request, resp *http.Response) {
+		if resp != nil {
+			message.Add("cache_status", "miss")
+		}
+	})
+	logger.AddEnricher(func(message *HttpMessage, req *http.Request, resp *http.Response) {
+		if req != nil {
+			message.Add("route", req.Method+" "+req.URL.Path)
+		}
+		message.Add("enriched_after_now", message.Get("now"))
+	})
+
+	req := MockGetRequestWithBody([]byte("{}"), "application/json")
+	resp := MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 1455908640173, 0, nil)
+	queue := logger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"cache_status\",\"cold\"]")
+	assert.Contains(t, queue[0], "[\"route\",\"POST /post\"]")
+	assert.Contains(t, queue[0], "[\"enriched_after_now\",\"1455908640173\"]")
+
+	message := NewHttpMessage()
+	message.SetRequestMethod("PUBLISH")
+	SubmitHttpMessage(logger, message, nil)
+	queue = logger.Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.NotContains(t, queue[1], "cache_status")
+	assert.NotContains(t, queue[1], "route")
+	assert.Contains(t, queue[1], "enriched_after_now")
+}
+
+func TestUsesSubmitListeners(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{
+		Queue: make([]string, 0),
+		Rules: "include debug\n/request_header:x-secret/ remove\n/.*/ stop when response_code 404",
+	})
+
+	var submitted []*HttpMessage
+	logger.AddSubmitListener(func(message *HttpMessage, req *http.Request, resp *http.Response) {
+		assert.NotNil(t, req)
+		assert.NotNil(t, resp)
+		submitted = append(submitted, message)
+	})
+
+	req := MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.Header.Set("X-Secret", "hidden")
+	resp := MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 0, 0, map[string]string{"tenant": "acme"})
+	assert.Equal(t, 1, len(submitted))
+	assert.False(t, submitted[0].Has("request_header:x-secret"))
+	assert.Equal(t, "acme", submitted[0].Get("custom_field:tenant"))
+
+	req = MockGetRequestWithBody([]byte("{}"), "application/json")
+	resp = MockGetPlainTextResponse(&req)
+	resp.StatusCode = 404
+	SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+	assert.Equal(t, 1, len(submitted))
+	assert.Equal(t, 1, len(logger.Queue()))
+}
+
+func TestPreservesCustomFieldValues(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{
+		Queue:                     make([]string, 0),
+		Rules:                     "include debug",
+		PreserveCustomFieldValues: true,
+	})
+	message := NewHttpMessage()
+	message.SetRequestMethod("GET")
+	SubmitHttpMessage(logger, message, map[string]string{
+		"Order-ID":           "AbC123",
+		"request_body\"],[x": "injected",
+		" ":                  "blank",
+	})
+	queue := logger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.True(t, parseable(queue[0]))
+	assert.Contains(t, queue[0], "[\"custom_field:order-id\",\"AbC123\"]")
+	assert.Contains(t, queue[0], "[\"custom_field:request_body____x\",\"injected\"]")
+	assert.NotContains(t, queue[0], "blank")
+
+	logger, _ = NewHttpLogger(Options{
+		Queue: make([]string, 0),
+		Rules: "include debug",
+	})
+	SubmitHttpMessage(logger, message, map[string]string{"Order-ID": "AbC123"})
+	assert.Contains(t, logger.Queue()[0], "[\"custom_field:order-id\",\"abc123\"]")
+}
@@ -0,0 +1,334 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// replacement for GraphQL variables masked by the mask_graphql_variable rule
+const graphqlMask = "*****"
+
+// graphqlRequest is a single GraphQL operation as sent over HTTP, in a JSON body or as query string parameters.
+type graphqlRequest struct {
+	Query         string                     `json:"query"`
+	OperationName string                     `json:"operationName"`
+	Variables     map[string]json.RawMessage `json:"variables"`
+}
+
+// graphqlOperation is an operation defined in a GraphQL document.
+type graphqlOperation struct {
+	kind string
+	name string
+}
+
+// adds the operation name, type and variables of GraphQL requests, if the request is one
+func appendGraphqlDetails(message *HttpMessage, req *http.Request, body string) {
+	for _, request := range parseGraphqlRequests(req, body) {
+		operation, ok := request.operation()
+		if !ok {
+			continue
+		}
+		if operation.name != "" {
+			message.Add("graphql_operation", operation.name)
+		}
+		message.Add("graphql_operation_type", operation.kind)
+
+		names := make([]string, 0, len(request.Variables))
+		for name := range request.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			message.Add("graphql_variable:"+name, graphqlValue(request.Variables[name]))
+		}
+	}
+}
+
+// returns the GraphQL requests carried by req, which may be batched in a JSON body
+func parseGraphqlRequests(req *http.Request, body string) []graphqlRequest {
+	contentType := strings.ToLower(req.Header.Get("Content-Type"))
+	trimmed := strings.TrimSpace(body)
+
+	switch {
+	case strings.HasPrefix(contentType, "application/graphql"):
+		return []graphqlRequest{{Query: body, OperationName: req.URL.Query().Get("operationName")}}
+	case strings.HasPrefix(trimmed, "{") && strings.Contains(trimmed, "\"query\""):
+		var request graphqlRequest
+		if json.Unmarshal([]byte(trimmed), &request) == nil {
+			return []graphqlRequest{request}
+		}
+	case strings.HasPrefix(trimmed, "[") && strings.Contains(trimmed, "\"query\""):
+		var requests []graphqlRequest
+		if json.Unmarshal([]byte(trimmed), &requests) == nil {
+			return requests
+		}
+	case req.Method == http.MethodGet && req.URL != nil:
+		query := req.URL.Query()
+		if query.Get("query") == "" {
+			return nil
+		}
+		request := graphqlRequest{Query: query.Get("query"), OperationName: query.Get("operationName")}
+		if variables := query.Get("variables"); variables != "" {
+			_ = json.Unmarshal([]byte(variables), &request.Variables)
+		}
+		return []graphqlRequest{request}
+	}
+	return nil
+}
+
+// returns the operation to be executed, as selected by the operation name if one was given
+func (request graphqlRequest) operation() (graphqlOperation, bool) {
+	operations := graphqlOperations(request.Query)
+	if len(operations) == 0 {
+		return graphqlOperation{}, false
+	}
+	if request.OperationName != "" {
+		for _, operation := range operations {
+			if operation.name == request.OperationName {
+				return operation, true
+			}
+		}
+		return graphqlOperation{}, false
+	}
+	return operations[0], true
+}
+
+// returns a variable value as logged, with strings unquoted
+func graphqlValue(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+	return string(value)
+}
+
+// returns the operations defined in a GraphQL document, or nil if it doesn't look like one
+func graphqlOperations(document string) []graphqlOperation {
+	var operations []graphqlOperation
+	i, n := 0, len(document)
+	for {
+		i = graphqlSkipIgnored(document, i)
+		if i >= n {
+			return operations
+		}
+		switch {
+		case document[i] == '{':
+			// shorthand query, without a keyword or name
+			operations = append(operations, graphqlOperation{kind: "query"})
+			if i = graphqlSkipBlock(document, i); i < 0 {
+				return nil
+			}
+		case graphqlIsNameStart(document[i]):
+			keyword, next := graphqlName(document, i)
+			if keyword != "query" && keyword != "mutation" && keyword != "subscription" && keyword != "fragment" {
+				return nil
+			}
+			next = graphqlSkipIgnored(document, next)
+			name := ""
+			if next < n && graphqlIsNameStart(document[next]) {
+				name, next = graphqlName(document, next)
+			}
+			// skip variable definitions, directives and type conditions up to the selection set
+			for next < n && document[next] != '{' {
+				if document[next] == '(' || document[next] == '"' {
+					if next = graphqlSkipBlock(document, next); next < 0 {
+						return nil
+					}
+				} else {
+					next++
+				}
+			}
+			if next >= n {
+				return nil
+			}
+			if keyword != "fragment" {
+				operations = append(operations, graphqlOperation{kind: keyword, name: name})
+			}
+			if i = graphqlSkipBlock(document, next); i < 0 {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// returns the position after whitespace, commas and comments
+func graphqlSkipIgnored(document string, i int) int {
+	for i < len(document) {
+		switch document[i] {
+		case ' ', '\t', '\n', '\r', ',':
+			i++
+		case '#':
+			for i < len(document) && document[i] != '\n' && document[i] != '\r' {
+				i++
+			}
+		default:
+			if strings.HasPrefix(document[i:], "\ufeff") {
+				i += len("\ufeff")
+				continue
+			}
+			return i
+		}
+	}
+	return i
+}
+
+// returns the position after the string, or the block opened by the bracket, at position i; or -1 if it isn't closed
+func graphqlSkipBlock(document string, i int) int {
+	var closers []byte
+	for i < len(document) {
+		c := document[i]
+		switch c {
+		case '"':
+			if strings.HasPrefix(document[i:], `"""`) {
+				end := strings.Index(strings.ReplaceAll(document[i+3:], `\"""`, `\xxx`), `"""`)
+				if end < 0 {
+					return -1
+				}
+				i += 3 + end + 3
+			} else {
+				i++
+				for i < len(document) && document[i] != '"' {
+					if document[i] == '\\' {
+						i++
+					}
+					i++
+				}
+				if i >= len(document) {
+					return -1
+				}
+				i++
+			}
+			if len(closers) == 0 {
+				return i
+			}
+			continue
+		case '#':
+			i = graphqlSkipIgnored(document, i)
+			continue
+		case '{':
+			closers = append(closers, '}')
+		case '(':
+			closers = append(closers, ')')
+		case '[':
+			closers = append(closers, ']')
+		case '}', ')', ']':
+			if len(closers) == 0 || closers[len(closers)-1] != c {
+				return -1
+			}
+			closers = closers[:len(closers)-1]
+			if len(closers) == 0 {
+				return i + 1
+			}
+		}
+		i++
+	}
+	return -1
+}
+
+func graphqlIsNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// returns the name at position i and the position after it
+func graphqlName(document string, i int) (string, int) {
+	start := i
+	for i < len(document) && (graphqlIsNameStart(document[i]) || (document[i] >= '0' && document[i] <= '9')) {
+		i++
+	}
+	return document[start:i], i
+}
+
+// masks GraphQL variables with names matching regex, in GraphQL details and in the request body or parameters they came from
+func maskGraphqlVariables(details [][]string, regex *regexp.Regexp) {
+	for _, d := range details {
+		switch {
+		case strings.HasPrefix(d[0], "graphql_variable:"):
+			if regex.MatchString(strings.TrimPrefix(d[0], "graphql_variable:")) {
+				d[1] = graphqlMask
+			} else if masked, ok := maskJsonKeys(d[1], regex); ok {
+				d[1] = masked
+			}
+		case d[0] == "request_body":
+			d[1] = maskGraphqlBody(d[1], regex)
+		case d[0] == "request_param:variables":
+			if masked, ok := maskJsonKeys(d[1], regex); ok {
+				d[1] = masked
+			}
+		}
+	}
+}
+
+// masks variables in a JSON request body holding a single or batched GraphQL request. Masked values are spliced into
+// the original body, which is otherwise left as it was.
+func maskGraphqlBody(body string, regex *regexp.Regexp) string {
+	if !strings.Contains(body, "\"variables\"") {
+		return body
+	}
+	root, err := parseJsonNodes(body)
+	if err != nil {
+		return body
+	}
+
+	requests := []*jsonNode{root}
+	if root.array {
+		requests = root.children
+	}
+	masked := false
+	for _, request := range requests {
+		if !request.object {
+			continue
+		}
+		for _, child := range request.children {
+			if child.key == "variables" && child.object && maskJsonNodeKeys(child, regex) {
+				request.edited = true
+				masked = true
+			}
+		}
+	}
+	if !masked {
+		return body
+	}
+	root.edited = true
+	return root.renderDocument(body)
+}
+
+// masks values with keys matching regex in a JSON object or array, at any depth
+func maskJsonKeys(value string, regex *regexp.Regexp) (string, bool) {
+	root, err := parseJsonNodes(value)
+	if err != nil || !maskJsonNodeKeys(root, regex) {
+		return value, false
+	}
+	return root.renderDocument(value), true
+}
+
+// returns true if any value was masked
+func maskJsonNodeKeys(node *jsonNode, regex *regexp.Regexp) bool {
+	masked := false
+	for _, child := range node.children {
+		if node.object && regex.MatchString(child.key) {
+			child.replaced, child.replacement = true, encodeJson(graphqlMask)
+			masked = true
+		} else {
+			masked = maskJsonNodeKeys(child, regex) || masked
+		}
+	}
+	node.edited = node.edited || masked
+	return masked
+}
+
+// encodes value as compact JSON, without escaping HTML characters
+func encodeJson(value interface{}) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
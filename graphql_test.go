@@ -0,0 +1,107 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func graphqlDetails(req *http.Request, body string) [][]string {
+	message := NewHttpMessage()
+	appendGraphqlDetails(message, req, body)
+	return message.details
+}
+
+func TestParsesGraphqlOperations(t *testing.T) {
+	assert.Equal(t, []graphqlOperation{{kind: "query"}}, graphqlOperations("{ me { id } }"))
+	assert.Equal(t, []graphqlOperation{{kind: "query", name: "Me"}}, graphqlOperations("query Me { me { id } }"))
+	assert.Equal(t, []graphqlOperation{{kind: "mutation"}}, graphqlOperations("mutation ($a: In = {x: \"}\"}) @live { a(a: $a) }"))
+	assert.Equal(t, []graphqlOperation{{kind: "subscription", name: "OnEvent"}, {kind: "query", name: "Other"}},
+		graphqlOperations("# comment\nfragment F on User { id }\nsubscription OnEvent { events { ...F } }\nquery Other { x(s: \"\"\"{\"\"\") }"))
+
+	assert.Nil(t, graphqlOperations(""))
+	assert.Nil(t, graphqlOperations("shoes"))
+	assert.Nil(t, graphqlOperations("query Me { me { id }"))
+	assert.Nil(t, graphqlOperations("query Me { me ) }"))
+}
+
+func TestAppendsGraphqlDetails(t *testing.T) {
+	request := MockGetRequestWithBody(nil, "application/json")
+	body := `{"query":"query A { a } query B($id: ID) { b(id: $id) }","operationName":"B","variables":{"id":"42","n":[1,2]}}`
+	assert.Equal(t, [][]string{
+		{"graphql_operation", "B"},
+		{"graphql_operation_type", "query"},
+		{"graphql_variable:id", "42"},
+		{"graphql_variable:n", "[1,2]"},
+	}, graphqlDetails(&request, body))
+
+	// batched requests
+	body = `[{"query":"{ a }"},{"query":"mutation M { m }"}]`
+	assert.Equal(t, [][]string{
+		{"graphql_operation_type", "query"},
+		{"graphql_operation", "M"},
+		{"graphql_operation_type", "mutation"},
+	}, graphqlDetails(&request, body))
+
+	// documents sent as application/graphql
+	request = MockGetRequestWithBody(nil, "application/graphql")
+	assert.Equal(t, [][]string{{"graphql_operation", "Me"}, {"graphql_operation_type", "query"}}, graphqlDetails(&request, "query Me { me }"))
+
+	// GET requests
+	request = MockGetNoBodyRequest()
+	request.URL.RawQuery = url.Values{"query": {"query Me { me }"}, "variables": {`{"v":true}`}}.Encode()
+	assert.Equal(t, [][]string{
+		{"graphql_operation", "Me"},
+		{"graphql_operation_type", "query"},
+		{"graphql_variable:v", "true"},
+	}, graphqlDetails(&request, ""))
+
+	// JSON bodies that aren't GraphQL
+	request = MockGetRequestWithBody(nil, "application/json")
+	assert.Nil(t, graphqlDetails(&request, `{"query":"shoes"}`))
+	assert.Nil(t, graphqlDetails(&request, `{"query":"query A { a }","operationName":"B"}`))
+	assert.Nil(t, graphqlDetails(&request, `{"hello":"world"}`))
+}
+
+func TestMasksGraphqlVariablesInPlace(t *testing.T) {
+	regex := regexp.MustCompile("^password$")
+
+	body := `{"query":"mutation L($password: String) { l }", "variables":{"password":"hunter2","n":12345678901234567890,"in":{"password":1}},"operationName":"L"}`
+	assert.Equal(t, `{"query":"mutation L($password: String) { l }", "variables":{"password":"*****","n":12345678901234567890,"in":{"password":"*****"}},"operationName":"L"}`,
+		maskGraphqlBody(body, regex))
+
+	// batched requests
+	body = "[ {\"query\":\"{ a }\"},\n  {\"variables\": {\"password\": \"x\"}, \"query\":\"mutation M { m }\"} ]"
+	assert.Equal(t, "[ {\"query\":\"{ a }\"},\n  {\"variables\": {\"password\": \"*****\"}, \"query\":\"mutation M { m }\"} ]", maskGraphqlBody(body, regex))
+
+	// bodies without variables to mask are left unchanged
+	body = `{"query":"{ a }","variables":{"id":1}}`
+	assert.Equal(t, body, maskGraphqlBody(body, regex))
+	assert.Equal(t, `{"variables":`, maskGraphqlBody(`{"variables":`, regex))
+
+	masked, ok := maskJsonKeys(`[{"b":1.50,"password":"x"}]`, regex)
+	assert.True(t, ok)
+	assert.Equal(t, `[{"b":1.50,"password":"*****"}]`, masked)
+	_, ok = maskJsonKeys(`{"b":1}`, regex)
+	assert.False(t, ok)
+}
+
+func TestRemovesGraphqlVariablesByDefault(t *testing.T) {
+	logger, _ := NewHttpLogger(Options{Queue: make([]string, 0)})
+	body := `{"query":"mutation Login($user: String, $password: String) { login(user: $user, password: $password) }","variables":{"user":"jdoe","password":"hunter2"}}`
+	req := MockGetRequestWithBody([]byte(body), "application/json")
+	resp := MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+
+	queue := logger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"graphql_operation\",\"Login\"]")
+	assert.NotContains(t, queue[0], "graphql_variable")
+	assert.NotContains(t, queue[0], "jdoe")
+	assert.NotContains(t, queue[0], "hunter2")
+}
@@ -0,0 +1,509 @@
+// © 2016-2024 Graylog, Inc.
+
+// Package grpclogger logs gRPC calls made or handled through interceptors.
+package grpclogger
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/resurfaceio/logger-go/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// HttpLoggerForGrpc defines a struct used to log gRPC calls through server and client interceptors.
+// Calls are converted into HTTP messages, with the full method name as the request path, metadata as headers,
+// the status as response code and grpc-status header, and protobuf messages rendered as JSON bodies.
+type HttpLoggerForGrpc struct {
+	HttpLogger *logger.HttpLogger
+}
+
+// grpcCall collects the details of a single gRPC call
+type grpcCall struct {
+	ctx             context.Context
+	method          string
+	authority       string
+	remoteAddr      string
+	tls             bool
+	requestMD       metadata.MD
+	requestBody     grpcBody
+	responseHeader  metadata.MD
+	responseTrailer metadata.MD
+	responseBody    grpcBody
+	err             error
+}
+
+// grpcBody renders messages as JSON, one per line for streams, up to LIMIT bytes
+type grpcBody struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewHttpLoggerForGrpc returns a pointer to an instance of an HttpLoggerForGrpc struct with the default options applied and an error.
+// If there is no error, the error value returned will be nil.
+func NewHttpLoggerForGrpc() (*HttpLoggerForGrpc, error) {
+	return NewHttpLoggerForGrpcOptions(logger.Options{})
+}
+
+// NewHttpLoggerForGrpcOptions(o Options) returns a pointer to a HttpLoggerForGrpc struct with the given options o applied and an error.
+// If there is no error, the error value returned will be nil.
+func NewHttpLoggerForGrpcOptions(options logger.Options) (*HttpLoggerForGrpc, error) {
+	// unlike older loggers, which lowercase them, this logger always keeps custom field values verbatim
+	options.PreserveCustomFieldValues = true
+	HttpLogger, err := logger.NewHttpLogger(options)
+	if err != nil {
+		return nil, err
+	}
+	return &HttpLoggerForGrpc{
+		HttpLogger: HttpLogger,
+	}, nil
+}
+
+// UnaryServerInterceptor() returns a grpc.UnaryServerInterceptor that logs every unary call handled by a server.
+// Use it with grpc.UnaryInterceptor or grpc.ChainUnaryInterceptor.
+func (grpcLogger HttpLoggerForGrpc) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !grpcLogger.HttpLogger.Enabled() {
+			return handler(ctx, req)
+		}
+
+		ctx = logger.WithCustomFields(ctx)
+		call := newGrpcServerCall(ctx, info.FullMethod)
+		call.requestBody.add(req)
+
+		if stream := grpc.ServerTransportStreamFromContext(ctx); stream != nil {
+			recorder := &grpcServerTransportStream{ServerTransportStream: stream, call: call}
+			ctx = grpc.NewContextWithServerTransportStream(ctx, recorder)
+		}
+
+		now := time.Now()
+		resp, err := handler(ctx, req)
+		if err == nil {
+			call.responseBody.add(resp)
+		}
+		call.err = err
+
+		grpcLogger.send(call, now)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor() returns a grpc.StreamServerInterceptor that logs every streaming call handled by a server,
+// once the handler returns. Use it with grpc.StreamInterceptor or grpc.ChainStreamInterceptor.
+func (grpcLogger HttpLoggerForGrpc) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !grpcLogger.HttpLogger.Enabled() {
+			return handler(srv, ss)
+		}
+
+		call := newGrpcServerCall(logger.WithCustomFields(ss.Context()), info.FullMethod)
+
+		now := time.Now()
+		err := handler(srv, &grpcServerStream{ServerStream: ss, call: call})
+		call.err = err
+
+		grpcLogger.send(call, now)
+		return err
+	}
+}
+
+// UnaryClientInterceptor() returns a grpc.UnaryClientInterceptor that logs every unary call made by a client.
+// Use it with grpc.WithUnaryInterceptor or grpc.WithChainUnaryInterceptor.
+func (grpcLogger HttpLoggerForGrpc) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !grpcLogger.HttpLogger.Enabled() {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		call := newGrpcClientCall(ctx, method, cc)
+		call.requestBody.add(req)
+
+		var header, trailer metadata.MD
+		opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer))
+
+		now := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			call.responseBody.add(reply)
+		}
+		call.responseHeader = header
+		call.responseTrailer = trailer
+		call.err = err
+
+		grpcLogger.send(call, now)
+		return err
+	}
+}
+
+// StreamClientInterceptor() returns a grpc.StreamClientInterceptor that logs every streaming call made by a client.
+// Calls are logged once the stream has been received to the end, has failed, or its context is done.
+// Use it with grpc.WithStreamInterceptor or grpc.WithChainStreamInterceptor.
+func (grpcLogger HttpLoggerForGrpc) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !grpcLogger.HttpLogger.Enabled() {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		call := newGrpcClientCall(ctx, method, cc)
+
+		now := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			call.err = err
+			grpcLogger.send(call, now)
+			return cs, err
+		}
+
+		stream := &grpcClientStream{
+			ClientStream:  cs,
+			call:          call,
+			serverStreams: desc.ServerStreams,
+			done: func() {
+				grpcLogger.send(call, now)
+			},
+			finished: make(chan struct{}),
+		}
+		go stream.watch(ctx)
+		return stream, nil
+	}
+}
+
+// converts the call to an HTTP message and submits it
+func (grpcLogger HttpLoggerForGrpc) send(call *grpcCall, now time.Time) {
+	if !grpcLogger.HttpLogger.Enabled() {
+		return
+	}
+
+	interval := time.Since(now).Milliseconds()
+
+	req := call.request()
+	resp := call.response(req)
+
+	logger.SendHttpMessage(grpcLogger.HttpLogger, resp, req, now.UnixNano()/int64(time.Millisecond), interval, nil)
+}
+
+func newGrpcServerCall(ctx context.Context, method string) *grpcCall {
+	call := &grpcCall{ctx: ctx, method: method}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		call.requestMD = md
+		if authority := md.Get(":authority"); len(authority) > 0 {
+			call.authority = authority[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			call.remoteAddr = p.Addr.String()
+		}
+		_, call.tls = p.AuthInfo.(credentials.TLSInfo)
+	}
+	return call
+}
+
+func newGrpcClientCall(ctx context.Context, method string, cc *grpc.ClientConn) *grpcCall {
+	call := &grpcCall{ctx: ctx, method: method}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		call.requestMD = md
+	}
+	if cc != nil {
+		// strip the resolver scheme from targets like dns:///host:port
+		target := cc.Target()
+		if idx := strings.Index(target, "://"); idx != -1 {
+			target = target[idx+3:]
+			target = target[strings.LastIndex(target, "/")+1:]
+		}
+		call.authority = target
+	}
+	return call
+}
+
+// returns an HTTP request equivalent to the call
+func (call *grpcCall) request() *http.Request {
+	req := &http.Request{
+		Method:     "POST",
+		URL:        &url.URL{Path: call.method},
+		Host:       call.authority,
+		Header:     grpcHeader(call.requestMD),
+		RemoteAddr: call.remoteAddr,
+		Body:       io.NopCloser(strings.NewReader(call.requestBody.String())),
+	}
+	if call.tls {
+		req.TLS = &tls.ConnectionState{}
+	}
+	return req.WithContext(call.ctx)
+}
+
+// returns an HTTP response equivalent to the call, with the gRPC status as response code and grpc-status header
+func (call *grpcCall) response(req *http.Request) *http.Response {
+	st := status.Convert(call.err)
+
+	header := grpcHeader(call.responseHeader)
+	for key, values := range grpcHeader(call.responseTrailer) {
+		header[key] = append(header[key], values...)
+	}
+	header.Set("grpc-status", strconv.Itoa(int(st.Code())))
+	if st.Message() != "" {
+		header.Set("grpc-message", st.Message())
+	}
+
+	resp := &http.Response{
+		StatusCode: grpcHttpStatus(st.Code()),
+		Header:     header,
+		Request:    req,
+	}
+	if body := call.responseBody.String(); body != "" {
+		resp.Body = io.NopCloser(strings.NewReader(body))
+	}
+	return resp
+}
+
+// converts metadata to headers, skipping pseudo-headers and encoding binary values
+func grpcHeader(md metadata.MD) http.Header {
+	header := http.Header{}
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				value = base64.StdEncoding.EncodeToString([]byte(value))
+			}
+			header.Add(key, value)
+		}
+	}
+	return header
+}
+
+// maps a gRPC status code to the closest HTTP status code
+func grpcHttpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// renders a message as JSON, using protojson for protobuf messages
+func (body *grpcBody) add(msg interface{}) {
+	var rendered []byte
+	var err error
+	if m, ok := msg.(proto.Message); ok {
+		// protojson output is deliberately unstable, so compact it
+		var compacted bytes.Buffer
+		if rendered, err = protojson.Marshal(m); err == nil {
+			if err = json.Compact(&compacted, rendered); err == nil {
+				rendered = compacted.Bytes()
+			}
+		}
+	} else {
+		rendered, err = json.Marshal(msg)
+	}
+	if err != nil {
+		return
+	}
+
+	body.mu.Lock()
+	defer body.mu.Unlock()
+	if body.buf.Len() > 0 {
+		rendered = append([]byte("\n"), rendered...)
+	}
+	if remaining := logger.LIMIT - body.buf.Len(); remaining > 0 {
+		if len(rendered) > remaining {
+			rendered = rendered[:remaining]
+		}
+		body.buf.Write(rendered)
+	}
+}
+
+func (body *grpcBody) String() string {
+	body.mu.Lock()
+	defer body.mu.Unlock()
+	return body.buf.String()
+}
+
+// grpcServerTransportStream records the header and trailer metadata set by unary handlers
+type grpcServerTransportStream struct {
+	grpc.ServerTransportStream
+	mu   sync.Mutex
+	call *grpcCall
+}
+
+func (s *grpcServerTransportStream) SetHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SetHeader(md)
+	if err == nil {
+		s.mu.Lock()
+		s.call.responseHeader = metadata.Join(s.call.responseHeader, md)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *grpcServerTransportStream) SendHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SendHeader(md)
+	if err == nil {
+		s.mu.Lock()
+		s.call.responseHeader = metadata.Join(s.call.responseHeader, md)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *grpcServerTransportStream) SetTrailer(md metadata.MD) error {
+	err := s.ServerTransportStream.SetTrailer(md)
+	if err == nil {
+		s.mu.Lock()
+		s.call.responseTrailer = metadata.Join(s.call.responseTrailer, md)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// grpcServerStream records the messages and metadata of a streaming call handled by a server
+type grpcServerStream struct {
+	grpc.ServerStream
+	mu   sync.Mutex
+	call *grpcCall
+}
+
+// Context() returns the context of the call, which can carry custom fields set by the handler
+func (s *grpcServerStream) Context() context.Context {
+	return s.call.ctx
+}
+
+func (s *grpcServerStream) SetHeader(md metadata.MD) error {
+	err := s.ServerStream.SetHeader(md)
+	if err == nil {
+		s.mu.Lock()
+		s.call.responseHeader = metadata.Join(s.call.responseHeader, md)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *grpcServerStream) SendHeader(md metadata.MD) error {
+	err := s.ServerStream.SendHeader(md)
+	if err == nil {
+		s.mu.Lock()
+		s.call.responseHeader = metadata.Join(s.call.responseHeader, md)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *grpcServerStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(md)
+	s.mu.Lock()
+	s.call.responseTrailer = metadata.Join(s.call.responseTrailer, md)
+	s.mu.Unlock()
+}
+
+func (s *grpcServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.call.responseBody.add(m)
+	}
+	return err
+}
+
+func (s *grpcServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.call.requestBody.add(m)
+	}
+	return err
+}
+
+// grpcClientStream records the messages and metadata of a streaming call made by a client
+type grpcClientStream struct {
+	grpc.ClientStream
+	call          *grpcCall
+	serverStreams bool
+	done          func()
+	once          sync.Once
+	finished      chan struct{}
+}
+
+func (s *grpcClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.call.requestBody.add(m)
+	} else if err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *grpcClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.call.responseBody.add(m)
+		// streams without server streaming end after their single response
+		if !s.serverStreams {
+			s.finish(nil)
+		}
+	} else if err == io.EOF {
+		s.finish(nil)
+	} else {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *grpcClientStream) finish(err error) {
+	s.once.Do(func() {
+		s.call.err = err
+		if header, headerErr := s.ClientStream.Header(); headerErr == nil {
+			s.call.responseHeader = header
+		}
+		s.call.responseTrailer = s.ClientStream.Trailer()
+		s.done()
+		close(s.finished)
+	})
+}
+
+// logs the call if its context is done before the stream is received to the end or fails,
+// like when the caller cancels the stream and abandons it without receiving again
+func (s *grpcClientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.finish(status.FromContextError(ctx.Err()).Err())
+	case <-s.finished:
+	}
+}
@@ -0,0 +1,183 @@
+// © 2016-2024 Graylog, Inc.
+
+package grpclogger
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	logger "github.com/resurfaceio/logger-go/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newGrpcTestConn(t *testing.T, server *grpc.Server, opts ...grpc.DialOption) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.Dial("passthrough:///bufnet", opts...)
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGrpcServerInterceptorsLogCalls(t *testing.T) {
+	grpcLogger, err := NewHttpLoggerForGrpcOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	assert.Nil(t, err)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcLogger.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(grpcLogger.StreamServerInterceptor()),
+	)
+	client := healthpb.NewHealthClient(newGrpcTestConn(t, server))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme")
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.Nil(t, err)
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	queue := grpcLogger.HttpLogger.Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.True(t, json.Valid([]byte(queue[0])))
+	assert.Contains(t, queue[0], "[\"request_method\",\"POST\"]")
+	assert.Contains(t, queue[0], "[\"request_url\",\"http://bufnet/grpc.health.v1.Health/Check\"]")
+	assert.Contains(t, queue[0], "[\"request_header:x-tenant\",\"acme\"]")
+	assert.Contains(t, queue[0], "[\"request_body\",\"{\\\"service\\\":\\\"orders\\\"}\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"200\"]")
+	assert.Contains(t, queue[0], "[\"response_header:grpc-status\",\"0\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"{\\\"status\\\":\\\"SERVING\\\"}\"]")
+	assert.NotContains(t, queue[0], ":authority")
+
+	assert.Contains(t, queue[1], "[\"response_code\",\"404\"]")
+	assert.Contains(t, queue[1], "[\"response_header:grpc-status\",\"5\"]")
+	assert.Contains(t, queue[1], "[\"response_header:grpc-message\",\"unknown service\"]")
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Nil(t, err)
+	server.Stop()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+
+	// server streams are logged once their handler returns, which may be after the client sees the stream end
+	assert.Eventually(t, func() bool { return grpcLogger.HttpLogger.SubmitSuccesses() == 3 }, time.Second, 10*time.Millisecond)
+	queue = grpcLogger.HttpLogger.Queue()
+	assert.Contains(t, queue[2], "[\"request_url\",\"http://bufnet/grpc.health.v1.Health/Watch\"]")
+	assert.Contains(t, queue[2], "[\"response_body\",\"{\\\"status\\\":\\\"SERVING\\\"}\"]")
+}
+
+func TestGrpcClientInterceptorsLogCalls(t *testing.T) {
+	grpcLogger, _ := NewHttpLoggerForGrpcOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug\n/request_header:authorization/ remove",
+	})
+	conn := newGrpcTestConn(t, grpc.NewServer(),
+		grpc.WithUnaryInterceptor(grpcLogger.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(grpcLogger.StreamClientInterceptor()),
+	)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "secret")
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.Nil(t, err)
+
+	queue := grpcLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"request_url\",\"http://bufnet/grpc.health.v1.Health/Check\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"{\\\"status\\\":\\\"SERVING\\\"}\"]")
+	assert.NotContains(t, queue[0], "secret")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Nil(t, err)
+	cancel()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	assert.NotEqual(t, io.EOF, err)
+
+	queue = grpcLogger.HttpLogger.Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.Contains(t, queue[1], "[\"response_code\",\"499\"]")
+	assert.Contains(t, queue[1], "[\"response_body\",\"{\\\"status\\\":\\\"SERVING\\\"}\"]")
+}
+
+func TestGrpcClientInterceptorsLogAbandonedStreams(t *testing.T) {
+	grpcLogger, _ := NewHttpLoggerForGrpcOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	conn := newGrpcTestConn(t, grpc.NewServer(),
+		grpc.WithStreamInterceptor(grpcLogger.StreamClientInterceptor()),
+	)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Nil(t, err)
+	cancel()
+
+	assert.Eventually(t, func() bool { return grpcLogger.HttpLogger.SubmitSuccesses() == 1 }, time.Second, 10*time.Millisecond)
+	queue := grpcLogger.HttpLogger.Queue()
+	assert.True(t, json.Valid([]byte(queue[0])))
+	assert.Contains(t, queue[0], "[\"request_url\",\"http://bufnet/grpc.health.v1.Health/Watch\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"499\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"{\\\"status\\\":\\\"SERVING\\\"}\"]")
+}
+
+func TestGrpcLoggerPreservesCustomFieldValues(t *testing.T) {
+	grpcLogger, _ := NewHttpLoggerForGrpcOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	conn := newGrpcTestConn(t, grpc.NewServer(),
+		grpc.WithUnaryInterceptor(grpcLogger.UnaryClientInterceptor()),
+	)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx := logger.WithCustomFields(context.Background())
+	logger.SetCustomField(ctx, "Order-ID", "AbC123")
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.Nil(t, err)
+
+	queue := grpcLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"custom_field:order-id\",\"AbC123\"]")
+}
@@ -0,0 +1,307 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed JSONPath expression, selecting values in a JSON document for remove_json and replace_json rules.
+// Supported are member names (.name or ['name']), array indexes ([0], or [-1] from the end), wildcards (.* or [*])
+// and recursive descent (..name).
+type jsonPath struct {
+	text     string
+	segments []jsonPathSegment
+}
+
+// jsonPathSegment selects children of the values selected by previous segments, or of all their descendants too
+type jsonPathSegment struct {
+	name       string
+	index      int
+	isIndex    bool
+	wildcard   bool
+	descendant bool
+}
+
+// returns the parsed JSONPath expression, or an error if it isn't valid or not supported
+func parseJsonPath(path string) (*jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath must start with $")
+	}
+	parsed := &jsonPath{text: path}
+	for i := 1; i < len(path); {
+		segment := jsonPathSegment{}
+		switch {
+		case strings.HasPrefix(path[i:], ".."):
+			segment.descendant = true
+			i += 2
+			if i < len(path) && path[i] == '[' {
+				break
+			}
+			fallthrough
+		case path[i] == '.':
+			if !segment.descendant {
+				i++
+			}
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("missing member name at position %d", i)
+			}
+			if strings.ContainsAny(path[i:end], " \t,'\"]") {
+				return nil, fmt.Errorf("invalid member name at position %d, use brackets for special characters", i)
+			}
+			segment.name = path[i:end]
+			segment.wildcard = segment.name == "*"
+			parsed.segments = append(parsed.segments, segment)
+			i = end
+			continue
+		case path[i] != '[':
+			return nil, fmt.Errorf("unexpected character at position %d", i)
+		}
+
+		// bracketed member name, index or wildcard
+		end := strings.IndexByte(path[i:], ']')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed bracket at position %d", i)
+		}
+		selector := path[i+1 : i+end]
+		if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+			segment.name = selector[1 : len(selector)-1]
+		} else if selector == "*" {
+			segment.wildcard = true
+		} else if index, err := strconv.Atoi(selector); err == nil {
+			segment.index, segment.isIndex = index, true
+		} else {
+			return nil, fmt.Errorf("invalid selector (%s) at position %d", selector, i)
+		}
+		parsed.segments = append(parsed.segments, segment)
+		i += end + 1
+	}
+	return parsed, nil
+}
+
+func (path *jsonPath) String() string {
+	return path.text
+}
+
+// jsonNode is a value parsed from a JSON document, with its offsets in the document, so that edits can be spliced into
+// the original text and leave the order of members and whitespace as they were
+type jsonNode struct {
+	key         string
+	keyStart    int
+	start       int
+	end         int
+	object      bool
+	array       bool
+	children    []*jsonNode
+	removed     bool
+	replaced    bool
+	replacement string
+	edited      bool
+}
+
+// parses a JSON document into nodes, or returns an error if it isn't a single valid JSON value
+func parseJsonNodes(value string) (*jsonNode, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	root, err := parseJsonNode(decoder, value)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return root, nil
+}
+
+func parseJsonNode(decoder *json.Decoder, value string) (*jsonNode, error) {
+	start := skipJsonSeparators(value, int(decoder.InputOffset()))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	node := &jsonNode{keyStart: start, start: start}
+	if delim, ok := token.(json.Delim); ok {
+		node.object, node.array = delim == '{', delim == '['
+		for decoder.More() {
+			keyStart := skipJsonSeparators(value, int(decoder.InputOffset()))
+			var key string
+			if node.object {
+				token, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ = token.(string)
+			}
+			child, err := parseJsonNode(decoder, value)
+			if err != nil {
+				return nil, err
+			}
+			child.key, child.keyStart = key, keyStart
+			node.children = append(node.children, child)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+	node.end = int(decoder.InputOffset())
+	return node, nil
+}
+
+// returns the offset of the next token at or after offset, skipping whitespace and the separators consumed by the decoder
+func skipJsonSeparators(value string, offset int) int {
+	for offset < len(value) && strings.IndexByte(" \t\r\n,:", value[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// returns the children of node that haven't been removed
+func (node *jsonNode) kept() []*jsonNode {
+	var kept []*jsonNode
+	for _, child := range node.children {
+		if !child.removed {
+			kept = append(kept, child)
+		}
+	}
+	return kept
+}
+
+// writes node as edited, copying the original text of everything left unchanged
+func (node *jsonNode) render(value string, b *strings.Builder) {
+	switch {
+	case node.replaced:
+		b.WriteString(node.replacement)
+		return
+	case !node.edited:
+		b.WriteString(value[node.start:node.end])
+		return
+	}
+	pos := node.start
+	children := node.children
+	for i := 0; i < len(children); {
+		if !children[i].removed {
+			b.WriteString(value[pos:children[i].start])
+			children[i].render(value, b)
+			pos = children[i].end
+			i++
+			continue
+		}
+		// cut runs of removed children along with the separator before the next child, or else after the previous one
+		j := i
+		for j+1 < len(children) && children[j+1].removed {
+			j++
+		}
+		from, to := children[i].keyStart, children[j].end
+		if j+1 < len(children) {
+			to = children[j+1].keyStart
+		} else if i > 0 {
+			from = children[i-1].end
+		}
+		b.WriteString(value[pos:from])
+		pos = to
+		i = j + 1
+	}
+	b.WriteString(value[pos:node.end])
+}
+
+// returns document value with node, its root value, as edited
+func (node *jsonNode) renderDocument(value string) string {
+	var b strings.Builder
+	b.WriteString(value[:node.start])
+	node.render(value, &b)
+	b.WriteString(value[node.end:])
+	return b.String()
+}
+
+// removes the values selected by path from node, returning whether any was removed
+func (path *jsonPath) remove(node *jsonNode) bool {
+	return editJsonPath(node, path.segments, "", true)
+}
+
+// replaces the values selected by path in node with replacement, returning whether any was replaced
+func (path *jsonPath) replace(node *jsonNode, replacement string) bool {
+	return editJsonPath(node, path.segments, encodeJson(replacement), false)
+}
+
+func editJsonPath(node *jsonNode, segments []jsonPathSegment, replacement string, remove bool) bool {
+	if len(segments) == 0 {
+		node.replaced, node.replacement = true, replacement
+		return true
+	}
+	if node.replaced || (!node.object && !node.array) {
+		return false
+	}
+	segment, rest := segments[0], segments[1:]
+	edited := false
+
+	children := node.kept()
+	for i, child := range children {
+		if !segment.wildcard {
+			if node.object && (segment.isIndex || child.key != segment.name) {
+				continue
+			}
+			if node.array && (!segment.isIndex || (i != segment.index && i != len(children)+segment.index)) {
+				continue
+			}
+		}
+		if len(rest) == 0 && remove {
+			child.removed = true
+			edited = true
+			continue
+		}
+		if editJsonPath(child, rest, replacement, remove) {
+			edited = true
+		}
+	}
+	if segment.descendant {
+		for _, child := range node.kept() {
+			if editJsonPath(child, segments, replacement, remove) {
+				edited = true
+			}
+		}
+	}
+	node.edited = node.edited || edited
+	return edited
+}
+
+// applies remove_json and replace_json rules to a JSON value, returning the edited value and whether it changed.
+// Edits are spliced into the original text, so members keep their order and unchanged parts keep their whitespace.
+// Values that aren't valid JSON are left unchanged.
+func editJson(value string, removes []*HttpRule, replaces []*HttpRule) (string, bool) {
+	root, err := parseJsonNodes(value)
+	if err != nil {
+		return value, false
+	}
+
+	edited := false
+	for _, r := range removes {
+		path := r.param1.(*jsonPath)
+		if len(path.segments) == 0 {
+			return "", true
+		}
+		if path.remove(root) {
+			edited = true
+		}
+	}
+	for _, r := range replaces {
+		if r.param1.(*jsonPath).replace(root, r.param2.(string)) {
+			edited = true
+		}
+	}
+	if !edited {
+		return value, false
+	}
+
+	if result := root.renderDocument(value); result != value {
+		return result, true
+	}
+	return value, false
+}
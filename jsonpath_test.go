@@ -0,0 +1,38 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditsJsonInPlace(t *testing.T) {
+	edit := func(value string, remove string, replace string) string {
+		var removes, replaces []*HttpRule
+		if remove != "" {
+			path, _ := parseJsonPath(remove)
+			removes = append(removes, &HttpRule{param1: path})
+		}
+		if replace != "" {
+			path, _ := parseJsonPath(replace)
+			replaces = append(replaces, &HttpRule{param1: path, param2: "x"})
+		}
+		edited, _ := editJson(value, removes, replaces)
+		return edited
+	}
+
+	assert.Equal(t, `{"b":2,"c":3}`, edit(`{"a":1,"b":2,"c":3}`, "$.a", ""))
+	assert.Equal(t, `{"a":1,"c":3}`, edit(`{"a":1,"b":2,"c":3}`, "$.b", ""))
+	assert.Equal(t, `{"a":1,"b":2}`, edit(`{"a":1,"b":2,"c":3}`, "$.c", ""))
+	assert.Equal(t, `{  }`, edit(`{ "a":1, "b":2 }`, "$.*", ""))
+	assert.Equal(t, `[1, 3]`, edit(`[1, 2, 3]`, "$[1]", ""))
+	assert.Equal(t, `[1, 2]`, edit(`[1, 2, 3]`, "$[-1]", ""))
+	assert.Equal(t, `{"a":[{"c":2}],"d":{}}`, edit(`{"a":[{"b":1,"c":2}],"d":{"b":{"e":3}}}`, "$..b", ""))
+	assert.Equal(t, ` "x" `, edit(` {"a":1} `, "", "$"))
+	assert.Equal(t, `{"a":"x","b":{"a":"x"}}`, edit(`{"a":{"a":1},"b":{"a":2}}`, "", "$..a"))
+	assert.Equal(t, `{"a":1}`, edit(`{"a":1}`, "$.b", "$.c"))
+	assert.Equal(t, `{"a":1} {}`, edit(`{"a":1} {}`, "$.a", ""))
+	assert.Equal(t, `not json`, edit(`not json`, "$.a", ""))
+}
@@ -0,0 +1,240 @@
+// © 2016-2024 Graylog, Inc.
+
+// Package lambdalogger logs API calls handled by AWS Lambda functions.
+package lambdalogger
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	logger "github.com/resurfaceio/logger-go/v3"
+)
+
+// HttpLoggerForLambda defines a struct used to log API calls handled by AWS Lambda functions,
+// behind API Gateway (REST and HTTP APIs) or an Application Load Balancer.
+type HttpLoggerForLambda struct {
+	HttpLogger *logger.HttpLogger
+}
+
+// NewHttpLoggerForLambda returns a pointer to an instance of an HttpLoggerForLambda struct with the default options applied and an error.
+// If there is no error, the error value returned will be nil.
+func NewHttpLoggerForLambda() (*HttpLoggerForLambda, error) {
+	return NewHttpLoggerForLambdaOptions(logger.Options{})
+}
+
+// NewHttpLoggerForLambdaOptions(o Options) returns a pointer to a HttpLoggerForLambda struct with the given options o applied and an error.
+// If there is no error, the error value returned will be nil.
+func NewHttpLoggerForLambdaOptions(options logger.Options) (*HttpLoggerForLambda, error) {
+	// unlike older loggers, which lowercase them, this logger always keeps custom field values verbatim
+	options.PreserveCustomFieldValues = true
+	HttpLogger, err := logger.NewHttpLogger(options)
+	if err != nil {
+		return nil, err
+	}
+	return &HttpLoggerForLambda{
+		HttpLogger: HttpLogger,
+	}, nil
+}
+
+// APIGatewayProxyHandler(h) wraps a Lambda handler for API Gateway REST API (v1 payload) events.
+// Each invocation is logged, and the logger is flushed before the wrapped handler returns, for no longer than ctx allows.
+func (lambdaLogger HttpLoggerForLambda) APIGatewayProxyHandler(handler func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		httpLogger := lambdaLogger.HttpLogger
+		ctx = logger.WithCustomFields(ctx)
+		now := time.Now()
+		resp, err := handler(ctx, req)
+		interval := time.Since(now).Milliseconds()
+		if err != nil {
+			logger.SendHttpErrorMessage(httpLogger, apiGatewayProxyRequest(req), err, now.UnixNano()/int64(time.Millisecond), interval, logger.CustomFields(ctx))
+		} else {
+			SendAPIGatewayProxyMessage(httpLogger, resp, req, now.UnixNano()/int64(time.Millisecond), interval, logger.CustomFields(ctx))
+		}
+		_ = httpLogger.FlushContext(ctx)
+		return resp, err
+	}
+}
+
+// APIGatewayV2HTTPHandler(h) wraps a Lambda handler for API Gateway HTTP API (v2 payload) events.
+// Each invocation is logged, and the logger is flushed before the wrapped handler returns, for no longer than ctx allows.
+func (lambdaLogger HttpLoggerForLambda) APIGatewayV2HTTPHandler(handler func(context.Context, events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error)) func(context.Context, events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	return func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		httpLogger := lambdaLogger.HttpLogger
+		ctx = logger.WithCustomFields(ctx)
+		now := time.Now()
+		resp, err := handler(ctx, req)
+		interval := time.Since(now).Milliseconds()
+		if err != nil {
+			logger.SendHttpErrorMessage(httpLogger, apiGatewayV2HTTPRequest(req), err, now.UnixNano()/int64(time.Millisecond), interval, logger.CustomFields(ctx))
+		} else {
+			SendAPIGatewayV2HTTPMessage(httpLogger, resp, req, now.UnixNano()/int64(time.Millisecond), interval, logger.CustomFields(ctx))
+		}
+		_ = httpLogger.FlushContext(ctx)
+		return resp, err
+	}
+}
+
+// ALBTargetGroupHandler(h) wraps a Lambda handler for Application Load Balancer events.
+// Each invocation is logged, and the logger is flushed before the wrapped handler returns, for no longer than ctx allows.
+func (lambdaLogger HttpLoggerForLambda) ALBTargetGroupHandler(handler func(context.Context, events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error)) func(context.Context, events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error) {
+	return func(ctx context.Context, req events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error) {
+		httpLogger := lambdaLogger.HttpLogger
+		ctx = logger.WithCustomFields(ctx)
+		now := time.Now()
+		resp, err := handler(ctx, req)
+		interval := time.Since(now).Milliseconds()
+		if err != nil {
+			logger.SendHttpErrorMessage(httpLogger, albTargetGroupRequest(req), err, now.UnixNano()/int64(time.Millisecond), interval, logger.CustomFields(ctx))
+		} else {
+			SendALBTargetGroupMessage(httpLogger, resp, req, now.UnixNano()/int64(time.Millisecond), interval, logger.CustomFields(ctx))
+		}
+		_ = httpLogger.FlushContext(ctx)
+		return resp, err
+	}
+}
+
+// SendAPIGatewayProxyMessage(l *HttpLogger, resp events.APIGatewayProxyResponse, req events.APIGatewayProxyRequest, now int64, interval int64) Uses logger l to send a log
+// of the given API Gateway REST API (v1 payload) event and response to the loggers url. now, interval and customFields are used as in SendHttpMessage.
+func SendAPIGatewayProxyMessage(httpLogger *logger.HttpLogger, resp events.APIGatewayProxyResponse, req events.APIGatewayProxyRequest, now int64, interval int64, customFields map[string]string) {
+	request := apiGatewayProxyRequest(req)
+	response := lambdaResponse(resp.StatusCode, resp.Headers, resp.MultiValueHeaders, nil, resp.Body, resp.IsBase64Encoded)
+	logger.SendHttpMessage(httpLogger, response, request, now, interval, customFields)
+}
+
+// SendAPIGatewayV2HTTPMessage(l *HttpLogger, resp events.APIGatewayV2HTTPResponse, req events.APIGatewayV2HTTPRequest, now int64, interval int64) Uses logger l to send a log
+// of the given API Gateway HTTP API (v2 payload) event and response to the loggers url. now, interval and customFields are used as in SendHttpMessage.
+func SendAPIGatewayV2HTTPMessage(httpLogger *logger.HttpLogger, resp events.APIGatewayV2HTTPResponse, req events.APIGatewayV2HTTPRequest, now int64, interval int64, customFields map[string]string) {
+	request := apiGatewayV2HTTPRequest(req)
+	response := lambdaResponse(resp.StatusCode, resp.Headers, resp.MultiValueHeaders, resp.Cookies, resp.Body, resp.IsBase64Encoded)
+	logger.SendHttpMessage(httpLogger, response, request, now, interval, customFields)
+}
+
+// SendALBTargetGroupMessage(l *HttpLogger, resp events.ALBTargetGroupResponse, req events.ALBTargetGroupRequest, now int64, interval int64) Uses logger l to send a log
+// of the given Application Load Balancer event and response to the loggers url. now, interval and customFields are used as in SendHttpMessage.
+func SendALBTargetGroupMessage(httpLogger *logger.HttpLogger, resp events.ALBTargetGroupResponse, req events.ALBTargetGroupRequest, now int64, interval int64, customFields map[string]string) {
+	request := albTargetGroupRequest(req)
+	response := lambdaResponse(resp.StatusCode, resp.Headers, resp.MultiValueHeaders, nil, resp.Body, resp.IsBase64Encoded)
+	logger.SendHttpMessage(httpLogger, response, request, now, interval, customFields)
+}
+
+func apiGatewayProxyRequest(req events.APIGatewayProxyRequest) *http.Request {
+	header := lambdaHeader(req.Headers, req.MultiValueHeaders)
+	query := lambdaQuery(req.QueryStringParameters, req.MultiValueQueryStringParameters)
+	host := header.Get("Host")
+	if host == "" {
+		host = req.RequestContext.DomainName
+	}
+	return lambdaRequest(req.HTTPMethod, host, req.Path, query.Encode(), header, req.RequestContext.Identity.SourceIP, req.Body, req.IsBase64Encoded)
+}
+
+func apiGatewayV2HTTPRequest(req events.APIGatewayV2HTTPRequest) *http.Request {
+	header := lambdaHeader(req.Headers, nil)
+	if len(req.Cookies) > 0 {
+		header.Set("Cookie", strings.Join(req.Cookies, "; "))
+	}
+	host := header.Get("Host")
+	if host == "" {
+		host = req.RequestContext.DomainName
+	}
+	httpContext := req.RequestContext.HTTP
+	return lambdaRequest(httpContext.Method, host, req.RawPath, req.RawQueryString, header, httpContext.SourceIP, req.Body, req.IsBase64Encoded)
+}
+
+func albTargetGroupRequest(req events.ALBTargetGroupRequest) *http.Request {
+	header := lambdaHeader(req.Headers, req.MultiValueHeaders)
+	query := lambdaQuery(req.QueryStringParameters, req.MultiValueQueryStringParameters)
+	return lambdaRequest(req.HTTPMethod, header.Get("Host"), req.Path, query.Encode(), header, "", req.Body, req.IsBase64Encoded)
+}
+
+// returns an HTTP request equivalent to a Lambda event, using X-Forwarded-Proto to tell the original scheme
+func lambdaRequest(method string, host string, path string, rawQuery string, header http.Header, sourceIP string, body string, isBase64Encoded bool) *http.Request {
+	scheme := "https"
+	if proto := header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.ToLower(proto)
+	}
+
+	req := &http.Request{
+		Method: method,
+		URL: &url.URL{
+			Scheme:   scheme,
+			Host:     host,
+			Path:     path,
+			RawQuery: rawQuery,
+		},
+		Host:       host,
+		Header:     header,
+		RemoteAddr: sourceIP,
+	}
+	if scheme == "https" {
+		req.TLS = &tls.ConnectionState{}
+	}
+	if body != "" {
+		req.Body = io.NopCloser(strings.NewReader(lambdaBody(body, isBase64Encoded)))
+	}
+	return req
+}
+
+// returns an HTTP response equivalent to a Lambda response
+func lambdaResponse(statusCode int, headers map[string]string, multiValueHeaders map[string][]string, cookies []string, body string, isBase64Encoded bool) *http.Response {
+	header := lambdaHeader(headers, multiValueHeaders)
+	for _, cookie := range cookies {
+		header.Add("Set-Cookie", cookie)
+	}
+
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Header:     header,
+	}
+	if body != "" {
+		resp.Body = io.NopCloser(strings.NewReader(lambdaBody(body, isBase64Encoded)))
+	}
+	return resp
+}
+
+// merges single and multi value headers, as events may carry either or both
+func lambdaHeader(headers map[string]string, multiValueHeaders map[string][]string) http.Header {
+	header := http.Header{}
+	for name, values := range multiValueHeaders {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	for name, value := range headers {
+		if _, found := header[http.CanonicalHeaderKey(name)]; !found {
+			header.Add(name, value)
+		}
+	}
+	return header
+}
+
+// merges single and multi value query string parameters, as events may carry either or both
+func lambdaQuery(params map[string]string, multiValueParams map[string][]string) url.Values {
+	query := url.Values{}
+	for name, values := range multiValueParams {
+		for _, value := range values {
+			query.Add(name, value)
+		}
+	}
+	for name, value := range params {
+		if _, found := query[name]; !found {
+			query.Add(name, value)
+		}
+	}
+	return query
+}
+
+func lambdaBody(body string, isBase64Encoded bool) string {
+	if isBase64Encoded {
+		if decoded, err := base64.StdEncoding.DecodeString(body); err == nil {
+			return string(decoded)
+		}
+	}
+	return body
+}
@@ -0,0 +1,136 @@
+// © 2016-2024 Graylog, Inc.
+
+package lambdalogger
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	logger "github.com/resurfaceio/logger-go/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLambdaLoggerLogsAPIGatewayProxyEvents(t *testing.T) {
+	lambdaLogger, err := NewHttpLoggerForLambdaOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	assert.Nil(t, err)
+
+	handler := lambdaLogger.APIGatewayProxyHandler(func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{
+			StatusCode:        201,
+			Headers:           map[string]string{"Content-Type": "application/json"},
+			MultiValueHeaders: map[string][]string{"Set-Cookie": {"a=1", "b=2"}},
+			Body:              base64.StdEncoding.EncodeToString([]byte(`{"id":1}`)),
+			IsBase64Encoded:   true,
+		}, nil
+	})
+
+	resp, err := handler(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod:                      "POST",
+		Path:                            "/orders",
+		Headers:                         map[string]string{"Host": "api.example.com", "Content-Type": "application/json"},
+		MultiValueQueryStringParameters: map[string][]string{"page": {"2"}},
+		RequestContext:                  events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{SourceIP: "203.0.113.7"}},
+		Body:                            `{"item":"book"}`,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+
+	queue := lambdaLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.True(t, json.Valid([]byte(queue[0])))
+	assert.Contains(t, queue[0], "[\"request_method\",\"POST\"]")
+	assert.Contains(t, queue[0], "[\"request_url\",\"https://api.example.com/orders?page=2\"]")
+	assert.Contains(t, queue[0], "[\"request_param:page\",\"2\"]")
+	assert.Contains(t, queue[0], "[\"request_body\",\"{\\\"item\\\":\\\"book\\\"}\"]")
+	assert.Contains(t, queue[0], "[\"request_header:x-forwarded-for\",\"203.0.113.7\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"201\"]")
+	assert.Contains(t, queue[0], "[\"response_header:set-cookie\",\"b=2\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"{\\\"id\\\":1}\"]")
+}
+
+func TestLambdaLoggerLogsAPIGatewayV2HTTPEvents(t *testing.T) {
+	lambdaLogger, _ := NewHttpLoggerForLambdaOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	handler := lambdaLogger.APIGatewayV2HTTPHandler(func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return events.APIGatewayV2HTTPResponse{StatusCode: 200, Body: "ok", Cookies: []string{"session=abc"}}, nil
+	})
+
+	_, err := handler(context.Background(), events.APIGatewayV2HTTPRequest{
+		RawPath:        "/items",
+		RawQueryString: "q=go",
+		Cookies:        []string{"token=xyz"},
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			DomainName: "abc.execute-api.us-east-1.amazonaws.com",
+			HTTP:       events.APIGatewayV2HTTPRequestContextHTTPDescription{Method: "GET", SourceIP: "198.51.100.1"},
+		},
+	})
+	assert.Nil(t, err)
+
+	queue := lambdaLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"request_url\",\"https://abc.execute-api.us-east-1.amazonaws.com/items?q=go\"]")
+	assert.Contains(t, queue[0], "[\"request_header:cookie\",\"token=xyz\"]")
+	assert.Contains(t, queue[0], "[\"session_field:token\",\"xyz\"]")
+	assert.Contains(t, queue[0], "[\"response_header:set-cookie\",\"session=abc\"]")
+	assert.Contains(t, queue[0], "[\"response_body\",\"ok\"]")
+}
+
+func TestLambdaLoggerLogsALBTargetGroupErrors(t *testing.T) {
+	lambdaLogger, _ := NewHttpLoggerForLambdaOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	handler := lambdaLogger.ALBTargetGroupHandler(func(ctx context.Context, req events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error) {
+		return events.ALBTargetGroupResponse{}, errors.New("boom")
+	})
+
+	_, err := handler(context.Background(), events.ALBTargetGroupRequest{
+		HTTPMethod: "GET",
+		Path:       "/health",
+		Headers:    map[string]string{"host": "internal.example.com", "x-forwarded-proto": "http"},
+	})
+	assert.NotNil(t, err)
+
+	queue := lambdaLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"request_url\",\"http://internal.example.com/health\"]")
+	assert.Contains(t, queue[0], "[\"response_code\",\"502\"]")
+	assert.Contains(t, queue[0], "[\"response_error\",\"error: boom\"]")
+}
+
+func TestLambdaLoggerPreservesCustomFieldValues(t *testing.T) {
+	lambdaLogger, _ := NewHttpLoggerForLambdaOptions(logger.Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+
+	handler := lambdaLogger.APIGatewayV2HTTPHandler(func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		logger.SetCustomField(ctx, "Order-ID", "AbC123")
+		return events.APIGatewayV2HTTPResponse{StatusCode: 200}, nil
+	})
+
+	_, err := handler(context.Background(), events.APIGatewayV2HTTPRequest{
+		RawPath:        "/orders",
+		RequestContext: events.APIGatewayV2HTTPRequestContext{HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{Method: "GET"}},
+	})
+	assert.Nil(t, err)
+
+	queue := lambdaLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"custom_field:order-id\",\"AbC123\"]")
+}
@@ -0,0 +1,140 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maximum length of text field values logged as request_param details
+const maxMultipartParamLength = 64 * 1024
+
+// multipartFile summarizes a file part of a multipart form, as logged in request_file details
+type multipartFile struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	Sha256      string `json:"sha256"`
+	Truncated   bool   `json:"truncated,omitempty"`
+}
+
+// returns the boundary of multipart/form-data requests, or an empty string for other requests
+func multipartBoundary(header http.Header) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// adds request_param details for the text fields of multipart/form-data requests, and request_file details
+// summarizing their files. Bodies already summarized as they were read by handlers or transports are used as they are,
+// and others are read here to the end, so files are counted and hashed without holding a copy of their content.
+func appendMultipartDetails(message *HttpMessage, req *http.Request) {
+	boundary := multipartBoundary(req.Header)
+	if boundary == "" || req.Body == nil {
+		return
+	}
+
+	var details [][]string
+	if summary, ok := req.Body.(*multipartSummary); ok {
+		details = summary.finish()
+	} else {
+		details = summarizeMultipart(req.Body, boundary)
+		req.Body.Close()
+	}
+	for _, d := range details {
+		message.Add(d[0], d[1])
+	}
+}
+
+// returns request_param and request_file details for the parts of a multipart/form-data body read from r.
+// Files that end early, like when the body wasn't read to the end, are marked as truncated.
+func summarizeMultipart(r io.Reader, boundary string) [][]string {
+	var details [][]string
+	reader := multipart.NewReader(r, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return details
+		}
+		name := strings.ToLower(part.FormName())
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxMultipartParamLength))
+			if err != nil {
+				return details
+			}
+			details = append(details, []string{"request_param:" + name, string(value)})
+			continue
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, part)
+		details = append(details, []string{"request_file:" + name, encodeJson(multipartFile{
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        size,
+			Sha256:      hex.EncodeToString(hash.Sum(nil)),
+			Truncated:   err != nil,
+		})})
+		if err != nil {
+			return details
+		}
+	}
+}
+
+// multipartSummary summarizes a multipart/form-data body written to it as the body is read, without keeping a copy.
+// It is logged in place of the body, which reads as empty.
+type multipartSummary struct {
+	writer  *io.PipeWriter
+	done    chan struct{}
+	once    sync.Once
+	details [][]string
+}
+
+func newMultipartSummary(boundary string) *multipartSummary {
+	reader, writer := io.Pipe()
+	summary := &multipartSummary{writer: writer, done: make(chan struct{})}
+	go func() {
+		defer close(summary.done)
+		summary.details = summarizeMultipart(reader, boundary)
+		// keeps consuming the body, so that reading it is never blocked
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+	return summary
+}
+
+// Write(p []byte) passes p on to be summarized. Bytes written once the body has ended are ignored.
+func (summary *multipartSummary) Write(p []byte) (int, error) {
+	_, _ = summary.writer.Write(p)
+	return len(p), nil
+}
+
+func (summary *multipartSummary) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (summary *multipartSummary) Close() error {
+	summary.finish()
+	return nil
+}
+
+// ends the body and returns the details summarizing it
+func (summary *multipartSummary) finish() [][]string {
+	summary.once.Do(func() {
+		summary.writer.Close()
+	})
+	<-summary.done
+	return summary.details
+}
@@ -0,0 +1,149 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendsMultipartDetails(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("Title", "Holiday photos")
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="photo"; filename="beach.png"`)
+	header.Set("Content-Type", "image/png")
+	part, _ := writer.CreatePart(header)
+	_, _ = part.Write([]byte("not really a png"))
+	file, _ := writer.CreateFormFile("notes", "notes.txt")
+	_, _ = file.Write([]byte("abc"))
+	_ = writer.Close()
+
+	req := MockGetRequestWithBody(body.Bytes(), writer.FormDataContentType())
+	message := NewHttpMessage()
+	appendMultipartDetails(message, &req)
+	assert.Equal(t, "Holiday photos", message.Get("request_param:title"))
+	assert.Equal(t, `{"filename":"beach.png","content_type":"image/png","size":16,`+
+		`"sha256":"e90137d39de304eefbbe788bc535c7e82f27abbf8069505fbbd8a9dcdc4f2024"}`, message.Get("request_file:photo"))
+	assert.Equal(t, `{"filename":"notes.txt","content_type":"application/octet-stream","size":3,`+
+		`"sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}`, message.Get("request_file:notes"))
+
+	// bodies may end within a file
+	req = MockGetRequestWithBody(body.Bytes()[:bytes.Index(body.Bytes(), []byte("really"))], writer.FormDataContentType())
+	message = NewHttpMessage()
+	appendMultipartDetails(message, &req)
+	assert.Equal(t, "Holiday photos", message.Get("request_param:title"))
+	assert.Contains(t, message.Get("request_file:photo"), `"size":4,`)
+	assert.Contains(t, message.Get("request_file:photo"), `"truncated":true`)
+	assert.False(t, message.Has("request_file:notes"))
+
+	// bodies may be summarized as they are read
+	req = MockGetRequestWithBody(body.Bytes(), writer.FormDataContentType())
+	capture := newRequestCaptureReadCloser(req.Body, req.Header)
+	_, _ = io.Copy(io.Discard, capture)
+	req.Body = capture.Logged()
+	message = NewHttpMessage()
+	appendMultipartDetails(message, &req)
+	assert.Equal(t, 3, message.Len())
+	assert.Equal(t, 0, len(capture.Bytes()))
+
+	req = MockGetRequestWithBody(body.Bytes(), "application/json")
+	message = NewHttpMessage()
+	appendMultipartDetails(message, &req)
+	assert.Equal(t, 0, message.Len())
+}
+
+func TestLogsMultipartForms(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("password", "hunter2")
+	file, _ := writer.CreateFormFile("upload", "data.bin")
+	_, _ = file.Write(bytes.Repeat([]byte{0}, 1024))
+	_ = writer.Close()
+
+	logger, _ := NewHttpLogger(Options{
+		Queue: make([]string, 0),
+		Rules: "include debug\n/request_param:password/ remove",
+	})
+	req := MockGetRequestWithBody(body.Bytes(), writer.FormDataContentType())
+	resp := MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+
+	queue := logger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.True(t, parseable(queue[0]))
+	assert.NotContains(t, queue[0], "hunter2")
+	assert.Contains(t, queue[0], "[\"request_file:upload\",\"{\\\"filename\\\":\\\"data.bin\\\",")
+	assert.Contains(t, queue[0], "\\\"size\\\":1024,")
+	assert.NotContains(t, queue[0], "request_body")
+}
+
+func TestRemovesMultipartDetailsByDefault(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("ssn", "123-45-6789")
+	file, _ := writer.CreateFormFile("return", "john-doe-tax-return-2025.pdf")
+	_, _ = file.Write([]byte("%PDF-1.7"))
+	_ = writer.Close()
+
+	logger, _ := NewHttpLogger(Options{Queue: make([]string, 0)})
+	req := MockGetRequestWithBody(body.Bytes(), writer.FormDataContentType())
+	resp := MockGetPlainTextResponse(&req)
+	SendHttpMessage(logger, &resp, &req, 0, 0, nil)
+
+	queue := logger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.NotContains(t, queue[0], "request_param")
+	assert.NotContains(t, queue[0], "request_file")
+	assert.NotContains(t, queue[0], "john-doe")
+	assert.NotContains(t, queue[0], "sha256")
+}
+
+func TestMuxLoggerSummarizesUploadsAsTheyAreRead(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*LIMIT/16)
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("title", "backup")
+	file, _ := writer.CreateFormFile("archive", "backup.tar")
+	_, _ = file.Write(content)
+	_ = writer.Close()
+
+	muxLogger, _ := NewHttpLoggerForMuxOptions(Options{
+		Queue: make([]string, 0),
+		Rules: "include debug",
+	})
+	server := httptest.NewServer(muxLogger.LogData(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1024); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		w.WriteHeader(201)
+	})))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/upload", writer.FormDataContentType(), &body)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 201, resp.StatusCode)
+
+	hash := sha256.Sum256(content)
+	queue := muxLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.True(t, parseable(queue[0]))
+	assert.Contains(t, queue[0], "[\"request_param:title\",\"backup\"]")
+	assert.Contains(t, queue[0], fmt.Sprintf("\\\"size\\\":%d,\\\"sha256\\\":\\\"%s\\\"}", len(content), hex.EncodeToString(hash[:])))
+	assert.NotContains(t, queue[0], "truncated")
+	assert.NotContains(t, queue[0], "request_body")
+}
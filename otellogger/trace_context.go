@@ -0,0 +1,81 @@
+// © 2016-2024 Graylog, Inc.
+
+// Package otellogger links messages logged by an HttpLogger to the OpenTelemetry spans recording their requests.
+package otellogger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	logger "github.com/resurfaceio/logger-go/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageIdAttribute is the span attribute holding the message_id detail of the message logged for the span
+const MessageIdAttribute = "resurface.message_id"
+
+// Options defines how messages are linked to spans.
+type Options struct {
+	//SpanAttributes adds a message_id detail to messages logged for requests with a recording span in their context,
+	//and sets it on the span as the resurface.message_id attribute once the message is submitted.
+	SpanAttributes bool
+}
+
+// Trace(l *logger.HttpLogger, o Options) makes logger l take the trace_id and span_id details of messages from the active
+// span in the request context, in preference to W3C Trace Context or B3 propagation headers, and link spans to their
+// messages as set by options o.
+func Trace(httpLogger *logger.HttpLogger, options Options) {
+	httpLogger.AddEnricher(appendSpanDetails)
+	if options.SpanAttributes {
+		httpLogger.AddEnricher(appendMessageId)
+		httpLogger.AddSubmitListener(setMessageIdAttribute)
+	}
+}
+
+// replaces the trace_id and span_id details of message with those of the active span in the request context
+func appendSpanDetails(message *logger.HttpMessage, req *http.Request, resp *http.Response) {
+	if req == nil {
+		return
+	}
+	spanContext := trace.SpanContextFromContext(req.Context())
+	if !spanContext.IsValid() {
+		return
+	}
+	message.Set("trace_id", spanContext.TraceID().String())
+	message.Set("span_id", spanContext.SpanID().String())
+}
+
+// adds a random message_id detail to message if the request is recorded by a span
+func appendMessageId(message *logger.HttpMessage, req *http.Request, resp *http.Response) {
+	if recordingSpan(req) == nil {
+		return
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	message.Set("message_id", hex.EncodeToString(id))
+}
+
+// sets the message_id detail of a submitted message on the span recording its request
+func setMessageIdAttribute(message *logger.HttpMessage, req *http.Request, resp *http.Response) {
+	span := recordingSpan(req)
+	if span == nil {
+		return
+	}
+	if messageId := message.Get("message_id"); messageId != "" {
+		span.SetAttributes(attribute.String(MessageIdAttribute, messageId))
+	}
+}
+
+// returns the span recording req, if there is one
+func recordingSpan(req *http.Request) trace.Span {
+	if req == nil {
+		return nil
+	}
+	span := trace.SpanFromContext(req.Context())
+	if !span.IsRecording() {
+		return nil
+	}
+	return span
+}
@@ -0,0 +1,96 @@
+// © 2016-2024 Graylog, Inc.
+
+package otellogger
+
+import (
+	"context"
+	"testing"
+
+	logger "github.com/resurfaceio/logger-go/v3"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testSpan keeps the attributes set on it
+type testSpan struct {
+	trace.Span
+	attributes []attribute.KeyValue
+}
+
+func (span *testSpan) IsRecording() bool {
+	return true
+}
+
+func (span *testSpan) SetAttributes(kv ...attribute.KeyValue) {
+	span.attributes = append(span.attributes, kv...)
+}
+
+func TestTakesTraceDetailsFromSpans(t *testing.T) {
+	httpLogger, _ := logger.NewHttpLogger(logger.Options{
+		Queue: make([]string, 0),
+		Rules: "include debug",
+	})
+	Trace(httpLogger, Options{})
+
+	// the active span takes precedence over headers
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01, 0x02},
+		SpanID:  trace.SpanID{0x03},
+	})
+	req := logger.MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req = *req.WithContext(trace.ContextWithSpanContext(req.Context(), spanContext))
+	resp := logger.MockGetPlainTextResponse(&req)
+	logger.SendHttpMessage(httpLogger, &resp, &req, 0, 0, nil)
+
+	req = logger.MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp = logger.MockGetPlainTextResponse(&req)
+	logger.SendHttpMessage(httpLogger, &resp, &req, 0, 0, nil)
+
+	queue := httpLogger.Queue()
+	assert.Equal(t, 2, len(queue))
+	assert.Contains(t, queue[0], "[\"trace_id\",\"01020000000000000000000000000000\"]")
+	assert.Contains(t, queue[0], "[\"span_id\",\"0300000000000000\"]")
+	assert.NotContains(t, queue[0], "[\"trace_id\",\"4bf92f3577b34da6a3ce929d0e0e4736\"]")
+	assert.Contains(t, queue[1], "[\"trace_id\",\"4bf92f3577b34da6a3ce929d0e0e4736\"]")
+	assert.Contains(t, queue[1], "[\"span_id\",\"00f067aa0ba902b7\"]")
+}
+
+func TestSetsMessageIdOnSpans(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		httpLogger, _ := logger.NewHttpLogger(logger.Options{
+			Queue: make([]string, 0),
+			Rules: "include debug\n/.*/ stop when response_code 404",
+		})
+		Trace(httpLogger, Options{SpanAttributes: enabled})
+
+		span := &testSpan{Span: trace.SpanFromContext(context.Background())}
+		req := logger.MockGetRequestWithBody([]byte("{}"), "application/json")
+		req = *req.WithContext(trace.ContextWithSpan(req.Context(), span))
+		resp := logger.MockGetPlainTextResponse(&req)
+		logger.SendHttpMessage(httpLogger, &resp, &req, 0, 0, nil)
+
+		queue := httpLogger.Queue()
+		assert.Equal(t, 1, len(queue))
+		if !enabled {
+			assert.NotContains(t, queue[0], "message_id")
+			assert.Equal(t, 0, len(span.attributes))
+			continue
+		}
+		assert.Equal(t, 1, len(span.attributes))
+		assert.Equal(t, attribute.Key(MessageIdAttribute), span.attributes[0].Key)
+		assert.Contains(t, queue[0], "[\"message_id\",\""+span.attributes[0].Value.AsString()+"\"]")
+
+		// spans aren't linked to messages stopped by rules
+		span = &testSpan{Span: trace.SpanFromContext(context.Background())}
+		req = logger.MockGetRequestWithBody([]byte("{}"), "application/json")
+		req = *req.WithContext(trace.ContextWithSpan(req.Context(), span))
+		resp = logger.MockGetPlainTextResponse(&req)
+		resp.StatusCode = 404
+		logger.SendHttpMessage(httpLogger, &resp, &req, 0, 0, nil)
+		assert.Equal(t, 1, len(httpLogger.Queue()))
+		assert.Equal(t, 0, len(span.attributes))
+	}
+}
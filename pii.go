@@ -0,0 +1,186 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// piiDetector finds a kind of personal or secret data for mask_pii rules, with candidates found by regex confirmed by
+// an optional validator to avoid masking values that only look alike
+type piiDetector struct {
+	name        string
+	regex       *regexp.Regexp
+	group       int
+	valid       func(candidate string) bool
+	replacement string
+}
+
+// detectors by name, in the order they are applied
+var piiDetectors = []*piiDetector{
+	{
+		name:        "jwt",
+		regex:       regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`),
+		valid:       validJwt,
+		replacement: "[JWT]",
+	},
+	{
+		name:        "bearer",
+		regex:       regexp.MustCompile(`(?i)\b(?:bearer)\s+([A-Za-z0-9\-._~+/]{16,}=*)`),
+		group:       1,
+		replacement: "[TOKEN]",
+	},
+	{
+		name:        "aws_key",
+		regex:       regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[A-Z0-9]{16}\b`),
+		replacement: "[AWS_KEY]",
+	},
+	{
+		name:        "iban",
+		regex:       regexp.MustCompile(`\b[A-Z]{2}[0-9]{2}(?:[A-Z0-9]{11,30}|(?: [A-Z0-9]{4}){2,7}(?: [A-Z0-9]{1,4})?)\b`),
+		valid:       validIban,
+		replacement: "[IBAN]",
+	},
+	{
+		name:        "card",
+		regex:       regexp.MustCompile(`\b(?:[2-6][0-9]{12,18}|[2-6][0-9]{3}(?:[ -][0-9]{4}){2}[ -][0-9]{1,7}|3[47][0-9]{2}[ -][0-9]{6}[ -][0-9]{5})\b`),
+		valid:       validCardNumber,
+		replacement: "[CARD]",
+	},
+	{
+		name:        "ssn",
+		regex:       regexp.MustCompile(`\b[0-9]{3}-[0-9]{2}-[0-9]{4}\b`),
+		valid:       validSsn,
+		replacement: "[SSN]",
+	},
+}
+
+// named sets of detectors, selectable in mask_pii rules along with single detectors
+var piiDetectorSets = map[string][]string{
+	"all":         {"jwt", "bearer", "aws_key", "iban", "card", "ssn"},
+	"credentials": {"jwt", "bearer", "aws_key"},
+	"financial":   {"iban", "card"},
+	"identity":    {"ssn"},
+}
+
+// returns the detectors selected by the given names of detectors or sets, in the order they are applied,
+// or the name that isn't valid
+func selectPiiDetectors(names []string) ([]*piiDetector, string) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		if set, ok := piiDetectorSets[name]; ok {
+			for _, detector := range set {
+				selected[detector] = true
+			}
+			continue
+		}
+		found := false
+		for _, detector := range piiDetectors {
+			if detector.name == name {
+				selected[name], found = true, true
+			}
+		}
+		if !found {
+			return nil, name
+		}
+	}
+	var detectors []*piiDetector
+	for _, detector := range piiDetectors {
+		if selected[detector.name] {
+			detectors = append(detectors, detector)
+		}
+	}
+	return detectors, ""
+}
+
+// masks values found by detectors, with replacement if given, or else the replacement of each detector
+func maskPii(value string, detectors []*piiDetector, replacement *string) string {
+	for _, detector := range detectors {
+		matches := detector.regex.FindAllStringSubmatchIndex(value, -1)
+		if matches == nil {
+			continue
+		}
+		var masked strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[2*detector.group], match[2*detector.group+1]
+			if detector.valid != nil && !detector.valid(value[start:end]) {
+				continue
+			}
+			masked.WriteString(value[last:start])
+			if replacement != nil {
+				masked.WriteString(*replacement)
+			} else {
+				masked.WriteString(detector.replacement)
+			}
+			last = end
+		}
+		masked.WriteString(value[last:])
+		value = masked.String()
+	}
+	return value
+}
+
+// returns true if the digits of a card number pass the Luhn check
+func validCardNumber(candidate string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(candidate)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// returns true if the check digits of an IBAN are valid (ISO 13616)
+func validIban(candidate string) bool {
+	iban := strings.ReplaceAll(candidate, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	// country code and check digits move to the end, and letters become numbers from 10 to 35
+	var numeric strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		if c >= 'A' && c <= 'Z' {
+			numeric.WriteString(strconv.Itoa(int(c-'A') + 10))
+		} else {
+			numeric.WriteRune(c)
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// returns true if a US social security number has a valid area, group and serial
+func validSsn(candidate string) bool {
+	area, group, serial := candidate[0:3], candidate[4:6], candidate[7:11]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// returns true if the header of a JWT decodes to a JSON object with an algorithm
+func validJwt(candidate string) bool {
+	header, err := base64.RawURLEncoding.DecodeString(candidate[:strings.IndexByte(candidate, '.')])
+	if err != nil {
+		return false
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(header, &fields) != nil {
+		return false
+	}
+	_, ok := fields["alg"]
+	return ok
+}
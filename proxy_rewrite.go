@@ -0,0 +1,18 @@
+// © 2016-2024 Graylog, Inc.
+
+//go:build go1.20
+
+package logger
+
+import "net/http/httputil"
+
+// hooks the Rewrite function of proxy p, which replaces Director since Go 1.20
+func hookRewrite(proxy *httputil.ReverseProxy) {
+	rewrite := proxy.Rewrite
+	if rewrite != nil {
+		proxy.Rewrite = func(pr *httputil.ProxyRequest) {
+			rewrite(pr)
+			startUpstream(pr.Out)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// © 2016-2024 Graylog, Inc.
+
+//go:build !go1.20
+
+package logger
+
+import "net/http/httputil"
+
+// proxies have no Rewrite function before Go 1.20
+func hookRewrite(proxy *httputil.ReverseProxy) {}
@@ -0,0 +1,48 @@
+// © 2016-2024 Graylog, Inc.
+
+//go:build go1.20
+
+package logger
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyLoggerHooksRewrite(t *testing.T) {
+	upstream := newTestServer()
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	proxyLogger, _ := NewHttpLoggerForProxyOptions(Options{
+		Queue:   make([]string, 0),
+		Enabled: true,
+		Rules:   "include debug",
+	})
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstreamURL)
+		},
+	}
+	gateway := httptest.NewServer(proxyLogger.LogProxy(proxy))
+	defer gateway.Close()
+
+	resp, err := http.Post(gateway.URL+"/orders", "text/plain", strings.NewReader("hello"))
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "echo: hello", string(body))
+
+	queue := proxyLogger.HttpLogger.Queue()
+	assert.Equal(t, 1, len(queue))
+	assert.Contains(t, queue[0], "[\"response_body\",\"echo: hello\"]")
+	assert.Contains(t, queue[0], "[\"upstream_host\",\""+upstreamURL.Host+"\"]")
+	assert.Contains(t, queue[0], "[\"upstream_interval\",\"")
+}
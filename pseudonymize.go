@@ -0,0 +1,62 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"unicode"
+)
+
+// number of HMAC-SHA256 bytes kept by hash rules, encoded as twice as many hex characters
+const hashLength = 8
+
+// returns the keyed hash of value, as hex
+func hashValue(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:hashLength])
+}
+
+// returns a token for value with the same format, where digits and letters are replaced by digits and letters derived
+// from its keyed hash, keeping case, and all other characters are kept
+func tokenizeValue(key []byte, value string) string {
+	runes := []rune(value)
+	var stream []byte
+	for i, r := range runes {
+		if !unicode.IsDigit(r) && !unicode.IsLetter(r) {
+			continue
+		}
+		if len(stream) == 0 {
+			// further blocks for values longer than a single digest
+			mac := hmac.New(sha256.New, key)
+			counter := make([]byte, 8)
+			binary.BigEndian.PutUint64(counter, uint64(i))
+			mac.Write(counter)
+			mac.Write([]byte(value))
+			stream = mac.Sum(nil)
+		}
+		b := stream[0]
+		stream = stream[1:]
+		switch {
+		case unicode.IsDigit(r):
+			runes[i] = rune('0' + b%10)
+		case unicode.IsUpper(r):
+			runes[i] = rune('A' + b%26)
+		default:
+			runes[i] = rune('a' + b%26)
+		}
+	}
+	return string(runes)
+}
+
+// replaces values matching regex, or the whole value if regex is nil, with the result of pseudonymize
+func pseudonymizeValue(value string, regex *regexp.Regexp, pseudonymize func(string) string) string {
+	if regex == nil {
+		return pseudonymize(value)
+	}
+	return regex.ReplaceAllStringFunc(value, pseudonymize)
+}
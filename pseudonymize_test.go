@@ -0,0 +1,31 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPseudonymizesValues(t *testing.T) {
+	key := []byte("secret")
+
+	hashed := hashValue(key, "ann@example.com")
+	assert.Regexp(t, "^[0-9a-f]{16}$", hashed)
+	assert.Equal(t, hashed, hashValue(key, "ann@example.com"))
+	assert.NotEqual(t, hashed, hashValue(key, "bob@example.com"))
+	assert.NotEqual(t, hashed, hashValue([]byte("other"), "ann@example.com"))
+
+	token := tokenizeValue(key, "123-45-6789 Ann")
+	assert.Regexp(t, "^[0-9]{3}-[0-9]{2}-[0-9]{4} [A-Z][a-z]{2}$", token)
+	assert.Equal(t, token, tokenizeValue(key, "123-45-6789 Ann"))
+	assert.NotEqual(t, token, tokenizeValue(key, "123-45-6780 Ann"))
+
+	// values longer than a single digest
+	long := strings.Repeat("4111111111111111", 4)
+	token = tokenizeValue(key, long)
+	assert.Regexp(t, "^[0-9]{64}$", token)
+	assert.NotEqual(t, token[:32], token[32:])
+}
@@ -0,0 +1,81 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maximum number of distinct values counted by a limit_per_minute rule in each window,
+// where further values share a single count
+const maxRateLimitKeys = 1024
+
+// rateLimit counts calls for a limit_per_minute rule, separately for each distinct value of the detail in scope,
+// in windows of one minute
+type rateLimit struct {
+	find     *regexp.Regexp
+	mu       sync.Mutex
+	window   int64
+	counts   map[uint64]int
+	overflow int
+}
+
+func newRateLimit(find *regexp.Regexp) *rateLimit {
+	return &rateLimit{find: find, counts: make(map[uint64]int)}
+}
+
+// returns false if the call is over the limit of the rule for the current window, or else counts it and returns true.
+// Calls without a detail in scope, with a value matching the optional regex, are always allowed.
+func (rule *HttpRule) allow(details [][]string) bool {
+	limit := rule.param2.(*rateLimit)
+	for _, d := range details {
+		if rule.scope.MatchString(d[0]) && (limit.find == nil || limit.find.MatchString(d[1])) {
+			return limit.take(d[1], rateLimitWindow(details), rule.param1.(int))
+		}
+	}
+	return true
+}
+
+func (limit *rateLimit) take(value string, window int64, max int) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	key := hash.Sum64()
+
+	limit.mu.Lock()
+	defer limit.mu.Unlock()
+	// windows only move forward, so calls logged out of order count against the current window instead of resetting it
+	if window > limit.window {
+		limit.window = window
+		limit.counts = make(map[uint64]int)
+		limit.overflow = 0
+	}
+	count, ok := limit.counts[key]
+	if !ok && len(limit.counts) >= maxRateLimitKeys {
+		if limit.overflow >= max {
+			return false
+		}
+		limit.overflow++
+		return true
+	}
+	if count >= max {
+		return false
+	}
+	limit.counts[key] = count + 1
+	return true
+}
+
+// returns the minute of the call, based on its now detail if present or else the current time
+func rateLimitWindow(details [][]string) int64 {
+	for _, d := range details {
+		if d[0] == "now" {
+			if now, err := strconv.ParseInt(d[1], 10, 64); err == nil {
+				return now / 60000
+			}
+		}
+	}
+	return time.Now().UnixMilli() / 60000
+}
@@ -0,0 +1,35 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsRates(t *testing.T) {
+	limit := newRateLimit(nil)
+	assert.True(t, limit.take("/a", 1, 2))
+	assert.True(t, limit.take("/a", 1, 2))
+	assert.False(t, limit.take("/a", 1, 2))
+	assert.True(t, limit.take("/b", 1, 2))
+	assert.True(t, limit.take("/a", 2, 2))
+
+	// calls from earlier windows count against the current one
+	assert.True(t, limit.take("/a", 1, 2))
+	assert.False(t, limit.take("/a", 2, 2))
+	assert.False(t, limit.take("/a", 1, 2))
+
+	// values past the maximum share a count
+	for i := 0; i < maxRateLimitKeys; i++ {
+		assert.True(t, limit.take(fmt.Sprint(i), 3, 1))
+	}
+	assert.Equal(t, maxRateLimitKeys, len(limit.counts))
+	assert.True(t, limit.take("x", 3, 1))
+	assert.False(t, limit.take("y", 3, 1))
+	assert.Equal(t, maxRateLimitKeys, len(limit.counts))
+
+	assert.Equal(t, int64(28333333), rateLimitWindow([][]string{{"now", "1700000000000"}}))
+}
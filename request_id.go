@@ -0,0 +1,99 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maximum length of request IDs read from incoming requests
+const maxRequestIdLength = 128
+
+// Crockford's base32 alphabet, used to encode ULIDs
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// context key used to attach the request ID to the request being handled
+type requestIdKey struct{}
+
+// RequestId(ctx context.Context) returns the request ID of the request handled with ctx, or "" if there is none.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// returns the request ID options with defaults applied, or an error if the format isn't supported
+func newRequestIdOptions(options RequestIdOptions) (RequestIdOptions, error) {
+	if options.Header == "" {
+		options.Header = "X-Request-Id"
+	}
+	switch options.Format {
+	case "":
+		options.Format = "uuid"
+	case "uuid", "ulid":
+	default:
+		return options, fmt.Errorf("invalid request ID format: %s", options.Format)
+	}
+	return options, nil
+}
+
+// returns the request ID of req, read from its header if valid or else generated, and a copy of ctx carrying it;
+// or "" and ctx itself if request IDs aren't enabled
+func (logger *HttpLogger) withRequestId(ctx context.Context, req *http.Request) (string, context.Context) {
+	options := logger.requestIds
+	if !options.Enabled {
+		return "", ctx
+	}
+	id := req.Header.Get(options.Header)
+	if !validRequestId(id) {
+		if options.Format == "ulid" {
+			id = newUlid(time.Now())
+		} else {
+			id = newUuid()
+		}
+	}
+	return id, context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// returns true if id isn't empty, isn't too long, and only has printable ASCII characters
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// returns a random (version 4) UUID
+func newUuid() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// returns a ULID for time t, with a 48-bit millisecond timestamp followed by 80 random bits
+func newUlid(t time.Time) string {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixNano()/int64(time.Millisecond))<<16)
+	_, _ = rand.Read(b[6:])
+
+	// 128 bits encoded as 26 characters of 5 bits each, with 2 leading zero bits
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	ulid := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		ulid[i] = ulidAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(ulid)
+}
@@ -0,0 +1,33 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratesRequestIds(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	assert.Regexp(t, uuid, newUuid())
+	assert.NotEqual(t, newUuid(), newUuid())
+
+	now := time.UnixMilli(1469918176385)
+	ulid := newUlid(now)
+	assert.Regexp(t, `^[0-9A-HJKMNP-TV-Z]{26}$`, ulid)
+	assert.Equal(t, "01ARYZ6S41", ulid[:10], "timestamp not encoded")
+	assert.True(t, ulid < newUlid(now.Add(time.Millisecond)), "not sortable by time")
+
+	assert.True(t, validRequestId("req-42"))
+	assert.False(t, validRequestId(""))
+	assert.False(t, validRequestId("req 42"))
+	assert.False(t, validRequestId("req\n42"))
+	assert.False(t, validRequestId(strings.Repeat("x", maxRequestIdLength+1)))
+
+	_, err := NewHttpLogger(Options{RequestId: RequestIdOptions{Enabled: true, Format: "snowflake"}})
+	assert.Equal(t, "invalid request ID format: snowflake", err.Error())
+}
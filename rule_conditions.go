@@ -0,0 +1,165 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ruleCondition limits a rule to calls where a detail, like response_code, request_method or interval, passes a test
+type ruleCondition struct {
+	name string
+	test func(value string) bool
+}
+
+// verbs of rules that may have conditions
+var conditionalVerbs = map[string]bool{
+	"limit_per_minute":    true,
+	"remove":              true,
+	"remove_if":           true,
+	"remove_if_found":     true,
+	"remove_unless":       true,
+	"remove_unless_found": true,
+	"sample":              true,
+	"stop":                true,
+	"stop_if":             true,
+	"stop_if_found":       true,
+	"stop_unless":         true,
+	"stop_unless_found":   true,
+}
+
+// Parses conditions joined by "and", for example "response_code 5xx,429 and interval > 2000".
+func parseRuleConditions(r string, text string) ([]ruleCondition, error) {
+	var conditions []ruleCondition
+	for _, c := range regexRuleConditionsAnd.Split(text, -1) {
+		m := regexRuleCondition.FindStringSubmatch(c)
+		if m == nil {
+			return nil, fmt.Errorf("invalid condition (%s) in rule: %s", c, r)
+		}
+		var test func(string) bool
+		switch m[1] {
+		case "interval":
+			test = parseIntervalCondition(m[2])
+		case "request_method":
+			test = parseMethodCondition(m[2])
+		case "response_code":
+			test = parseResponseCodeCondition(m[2])
+		}
+		if test == nil {
+			return nil, fmt.Errorf("invalid condition (%s) in rule: %s", c, r)
+		}
+		conditions = append(conditions, ruleCondition{name: m[1], test: test})
+	}
+	return conditions, nil
+}
+
+// Parses comparison of interval in milliseconds, like "> 2000".
+func parseIntervalCondition(text string) func(string) bool {
+	m := regexIntervalCondition.FindStringSubmatch(text)
+	if m == nil {
+		return nil
+	}
+	limit, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return nil
+	}
+	op := m[1]
+	return func(value string) bool {
+		interval, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		switch op {
+		case "<":
+			return interval < float64(limit)
+		case "<=":
+			return interval <= float64(limit)
+		case ">":
+			return interval > float64(limit)
+		default:
+			return interval >= float64(limit)
+		}
+	}
+}
+
+// Parses list of methods, like "POST,PUT".
+func parseMethodCondition(text string) func(string) bool {
+	methods := map[string]bool{}
+	for _, method := range strings.Split(text, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if !regexMethodCondition.MatchString(method) {
+			return nil
+		}
+		methods[method] = true
+	}
+	return func(value string) bool {
+		return methods[strings.ToUpper(value)]
+	}
+}
+
+// Parses list of response codes, ranges of codes and classes of codes, like "404,500-503,2xx".
+func parseResponseCodeCondition(text string) func(string) bool {
+	type codeRange struct{ low, high int }
+	var ranges []codeRange
+	for _, item := range strings.Split(text, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		m := regexResponseCodeCondition.FindStringSubmatch(item)
+		switch {
+		case m == nil:
+			return nil
+		case m[4] != "":
+			class, _ := strconv.Atoi(m[4])
+			ranges = append(ranges, codeRange{class * 100, class*100 + 99})
+		case m[3] != "":
+			low, _ := strconv.Atoi(m[1])
+			high, _ := strconv.Atoi(m[3])
+			if low > high {
+				return nil
+			}
+			ranges = append(ranges, codeRange{low, high})
+		default:
+			code, _ := strconv.Atoi(m[1])
+			ranges = append(ranges, codeRange{code, code})
+		}
+	}
+	return func(value string) bool {
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return false
+		}
+		for _, r := range ranges {
+			if code >= r.low && code <= r.high {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// returns true if the rule has no conditions, or if all of its conditions pass for the given details.
+// Conditions on details that are missing never pass.
+func (rule *HttpRule) when(details [][]string) bool {
+	for _, c := range rule.conditions {
+		passed := false
+		for _, d := range details {
+			if d[0] == c.name {
+				passed = c.test(d[1])
+				break
+			}
+		}
+		if !passed {
+			return false
+		}
+	}
+	return true
+}
+
+var regexRuleConditions *regexp.Regexp = regexp.MustCompile(`^(.*?)\s+when\s+((?:interval|request_method|response_code)\s[^~!%|\/#]*?)\s*(#.*)?$`)
+var regexRuleCondition *regexp.Regexp = regexp.MustCompile(`^(interval|request_method|response_code)\s+(.+)$`)
+var regexRuleConditionsAnd *regexp.Regexp = regexp.MustCompile(`\s+and\s+`)
+var regexIntervalCondition *regexp.Regexp = regexp.MustCompile(`^(<=|>=|<|>)\s*(\d+)$`)
+var regexMethodCondition *regexp.Regexp = regexp.MustCompile(`^[A-Z]+$`)
+var regexResponseCodeCondition *regexp.Regexp = regexp.MustCompile(`^(\d{3})(-(\d{3}))?$|^([1-5])xx$`)
@@ -0,0 +1,71 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"regexp"
+)
+
+// sampling holds the optional params of a sample rule: a regex that values of details in scope must match for the rule
+// to apply, and the detail whose value (or the part of it found by regex) decides whether a call is kept
+type sampling struct {
+	find    *regexp.Regexp
+	key     *regexp.Regexp
+	keyFind *regexp.Regexp
+}
+
+// returns true if the sample rule applies to the given details
+func (rule *HttpRule) sampled(details [][]string) bool {
+	if rule.scope == nil {
+		return true
+	}
+	var find *regexp.Regexp
+	if s, ok := rule.param2.(*sampling); ok {
+		find = s.find
+	}
+	for _, d := range details {
+		if rule.scope.MatchString(d[0]) && (find == nil || find.MatchString(d[1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if a call is kept by the sample rule, at random or deterministically by its key
+func (rule *HttpRule) sample(details [][]string) bool {
+	percent := rule.param1.(int)
+	if s, ok := rule.param2.(*sampling); ok && s.key != nil {
+		if key, found := sampleKey(details, s.key, s.keyFind); found {
+			return sampleBucket(key) < percent
+		}
+	}
+	return rand.Intn(100) < percent
+}
+
+// returns the value of the first detail matching key, or its first submatch (or match) of find if given
+func sampleKey(details [][]string, key *regexp.Regexp, find *regexp.Regexp) (string, bool) {
+	for _, d := range details {
+		if !key.MatchString(d[0]) {
+			continue
+		}
+		if find == nil {
+			return d[1], d[1] != ""
+		}
+		if m := find.FindStringSubmatch(d[1]); len(m) > 1 {
+			return m[1], true
+		} else if m != nil {
+			return m[0], true
+		}
+	}
+	return "", false
+}
+
+// returns a bucket from 0 to 99 for the key, so calls with the same key are all kept or all dropped,
+// and calls kept at a lower percent are also kept at higher ones
+func sampleBucket(key string) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return int(hash.Sum64() % 100)
+}
@@ -0,0 +1,491 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// limit on the size of the handshake response written to a hijacked connection
+const handshakeLimit = 16 * 1024
+
+// httpSession tracks a call that outlives its handler response, either because the connection was hijacked
+// (e.g. upgraded to a WebSocket) or because the response is a Server-Sent Events stream.
+type httpSession struct {
+	logger    *HttpLogger
+	req       *http.Request
+	start     time.Time
+	websocket bool
+
+	mu              sync.Mutex
+	handshake       *http.Response
+	handshakeHead   []byte
+	handshakeLogged bool
+	streaming       bool
+	ended           bool
+	transcript      *sessionTranscript
+	inbound         wsFrameParser
+	outbound        wsFrameParser
+	events          sseEventParser
+}
+
+func newHttpSession(logger *HttpLogger, req *http.Request, start time.Time) *httpSession {
+	session := &httpSession{
+		logger:    logger,
+		req:       req,
+		start:     start,
+		websocket: strings.EqualFold(req.Header.Get("Upgrade"), "websocket"),
+	}
+	if logger.sessions.Enabled && logger.sessions.Transcript {
+		session.transcript = &sessionTranscript{
+			maxEvents:  logger.sessions.MaxEvents,
+			maxPayload: logger.sessions.MaxPayload,
+		}
+	}
+	return session
+}
+
+// called when the response head is sent through the response writer, to detect Server-Sent Events streams
+func (session *httpSession) respond(statusCode int, header http.Header) {
+	if !session.logger.sessions.Enabled || !strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	session.mu.Lock()
+	session.streaming = true
+	session.handshake = &http.Response{StatusCode: statusCode, Header: header.Clone()}
+	session.mu.Unlock()
+	session.logHandshake()
+}
+
+// returns true if the response is a Server-Sent Events stream being logged as a session
+func (session *httpSession) isStreaming() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.streaming
+}
+
+// records events written to a Server-Sent Events stream
+func (session *httpSession) streamWritten(data []byte) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.transcript != nil {
+		session.events.feed(data, session.transcript.maxPayload, func(size int64, payload []byte) {
+			session.transcript.record(true, "event", size, payload)
+		})
+	}
+}
+
+// records bytes read from a hijacked connection
+func (session *httpSession) connRead(data []byte) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.recordFrames(false, data)
+}
+
+// records bytes written to a hijacked connection, starting with the handshake response
+func (session *httpSession) connWritten(data []byte) {
+	session.mu.Lock()
+	var parsed bool
+	if session.handshake == nil {
+		data = session.parseHandshake(data)
+		parsed = session.handshake != nil
+	}
+	session.recordFrames(true, data)
+	session.mu.Unlock()
+
+	if parsed && session.logger.sessions.Enabled {
+		session.logHandshake()
+	}
+}
+
+// accumulates the handshake response and returns whatever was written past it
+func (session *httpSession) parseHandshake(data []byte) []byte {
+	session.handshakeHead = append(session.handshakeHead, data...)
+	end := bytes.Index(session.handshakeHead, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(session.handshakeHead) > handshakeLimit {
+			session.handshake = session.defaultHandshake()
+			data, session.handshakeHead = session.handshakeHead, nil
+			return data
+		}
+		return nil
+	}
+
+	head, rest := session.handshakeHead[:end+4], session.handshakeHead[end+4:]
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), session.req)
+	if err != nil {
+		session.handshake = session.defaultHandshake()
+		rest = session.handshakeHead
+	} else {
+		session.handshake = &http.Response{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+	session.handshakeHead = nil
+	return rest
+}
+
+// used when the handshake response can't be parsed
+func (session *httpSession) defaultHandshake() *http.Response {
+	if session.req.Header.Get("Upgrade") != "" {
+		return &http.Response{StatusCode: http.StatusSwitchingProtocols, Header: http.Header{}}
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+}
+
+func (session *httpSession) recordFrames(outbound bool, data []byte) {
+	if session.transcript == nil || len(data) == 0 {
+		return
+	}
+	if !session.websocket {
+		session.transcript.record(outbound, "data", int64(len(data)), data)
+		return
+	}
+	parser := &session.inbound
+	if outbound {
+		parser = &session.outbound
+	}
+	parser.feed(data, session.transcript.maxPayload, func(opcode byte, size int64, payload []byte) {
+		session.transcript.record(outbound, wsOpcodeName(opcode), size, payload)
+	})
+}
+
+// logs the handshake once, with the request body (if any) and no response body
+func (session *httpSession) logHandshake() {
+	session.mu.Lock()
+	if session.handshakeLogged {
+		session.mu.Unlock()
+		return
+	}
+	session.handshakeLogged = true
+	resp := session.handshake
+	if resp == nil {
+		resp = session.defaultHandshake()
+	}
+	session.mu.Unlock()
+
+	logger := session.logger
+	if !logger.Enabled() {
+		return
+	}
+	req := session.loggingRequest()
+	interval := time.Since(session.start).Milliseconds()
+	message := buildHttpMessage(req, resp)
+	sendHttpMessageDetails(logger, message, req, resp, session.start.UnixNano()/int64(time.Millisecond), interval, nil)
+}
+
+// returns a copy of the request to build a message from, since building one changes the request's URL and form,
+// which are shared with the request the handler may still be using
+func (session *httpSession) loggingRequest() *http.Request {
+	return session.req.Clone(session.req.Context())
+}
+
+// ends the session once, logging its transcript if one was recorded
+func (session *httpSession) end() {
+	session.mu.Lock()
+	if session.ended {
+		session.mu.Unlock()
+		return
+	}
+	session.ended = true
+	if session.transcript == nil {
+		session.mu.Unlock()
+		return
+	}
+	transcript := NewHttpMessage()
+	session.transcript.appendDetails(transcript)
+	resp := session.handshake
+	if resp == nil {
+		resp = session.defaultHandshake()
+	}
+	session.mu.Unlock()
+
+	if !session.logger.Enabled() {
+		return
+	}
+
+	// the request body was logged with the handshake, bodies here hold the transcript instead. An empty body still has
+	// the query string parsed into request_param details.
+	req := session.loggingRequest()
+	req.Body = http.NoBody
+	loggingResp := &http.Response{StatusCode: resp.StatusCode, Header: resp.Header}
+	message := buildHttpMessage(req, loggingResp)
+	message.details = append(message.details, transcript.details...)
+
+	interval := time.Since(session.start).Milliseconds()
+	sendHttpMessageDetails(session.logger, message, req, loggingResp, session.start.UnixNano()/int64(time.Millisecond), interval, nil)
+}
+
+// sessionConn wraps a hijacked connection to record the session taking place over it.
+type sessionConn struct {
+	net.Conn
+	session  *httpSession
+	buffered []byte
+}
+
+// returns a copy of rw that reads and writes through conn, keeping any data the server had already buffered
+func newSessionReadWriter(conn *sessionConn, rw *bufio.ReadWriter) *bufio.ReadWriter {
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		conn.buffered = append([]byte(nil), buffered...)
+	}
+	return bufio.NewReadWriter(bufio.NewReaderSize(conn, rw.Reader.Size()), bufio.NewWriterSize(conn, rw.Writer.Size()))
+}
+
+func (conn *sessionConn) Read(b []byte) (int, error) {
+	if len(conn.buffered) > 0 {
+		n := copy(b, conn.buffered)
+		conn.buffered = conn.buffered[n:]
+		conn.session.connRead(b[:n])
+		return n, nil
+	}
+	n, err := conn.Conn.Read(b)
+	if n > 0 {
+		conn.session.connRead(b[:n])
+	}
+	return n, err
+}
+
+func (conn *sessionConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	if n > 0 {
+		conn.session.connWritten(b[:n])
+	}
+	return n, err
+}
+
+func (conn *sessionConn) Close() error {
+	err := conn.Conn.Close()
+	conn.session.end()
+	return err
+}
+
+// sessionTranscript records frames or events as JSON lines, client frames in the request body and
+// server frames in the response body, so that rules for bodies apply to the transcript as well.
+type sessionTranscript struct {
+	maxEvents  int
+	maxPayload int
+	events     int
+	request    bytes.Buffer
+	response   bytes.Buffer
+}
+
+type sessionEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	Size      int64  `json:"size"`
+	Payload   string `json:"payload,omitempty"`
+	Base64    bool   `json:"base64,omitempty"`
+}
+
+// records a single frame or event, unless the transcript is full
+func (transcript *sessionTranscript) record(outbound bool, kind string, size int64, payload []byte) {
+	transcript.events++
+	if transcript.events > transcript.maxEvents {
+		return
+	}
+
+	if len(payload) > transcript.maxPayload {
+		payload = payload[:transcript.maxPayload]
+	}
+	event := sessionEvent{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Type:      kind,
+		Size:      size,
+	}
+	if text := validPrefix(payload, int64(len(payload)) < size); text != nil {
+		event.Payload = string(text)
+	} else {
+		event.Payload = base64.StdEncoding.EncodeToString(payload)
+		event.Base64 = true
+	}
+	line, _ := json.Marshal(event)
+
+	buf := &transcript.request
+	if outbound {
+		buf = &transcript.response
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+}
+
+// adds details for the recorded transcript, along with the total number of frames or events seen
+func (transcript *sessionTranscript) appendDetails(message *HttpMessage) {
+	if transcript.request.Len() > 0 {
+		message.Add("request_body", strings.TrimSuffix(transcript.request.String(), "\n"))
+	}
+	if transcript.response.Len() > 0 {
+		message.Add("response_body", strings.TrimSuffix(transcript.response.String(), "\n"))
+	}
+	message.Add("session_events", strconv.Itoa(transcript.events))
+}
+
+// returns payload if it is valid UTF-8, allowing a rune cut short by truncation, or nil otherwise
+func validPrefix(payload []byte, truncated bool) []byte {
+	if utf8.Valid(payload) {
+		return payload
+	}
+	if truncated {
+		for i := 1; i < utf8.UTFMax && i <= len(payload); i++ {
+			if prefix := payload[:len(payload)-i]; utf8.Valid(prefix) {
+				return prefix
+			}
+		}
+	}
+	return nil
+}
+
+// wsFrameParser splits a stream of WebSocket frames (RFC 6455) into frames, keeping no more than
+// a limited number of payload bytes per frame.
+type wsFrameParser struct {
+	header    []byte
+	inPayload bool
+	opcode    byte
+	masked    bool
+	mask      [4]byte
+	size      int64
+	remaining int64
+	payload   []byte
+}
+
+func (parser *wsFrameParser) feed(data []byte, maxPayload int, emit func(opcode byte, size int64, payload []byte)) {
+	for len(data) > 0 {
+		if !parser.inPayload {
+			needed := 2
+			if len(parser.header) >= 2 {
+				needed = wsHeaderLength(parser.header[1])
+			}
+			n := needed - len(parser.header)
+			if n > len(data) {
+				n = len(data)
+			}
+			parser.header = append(parser.header, data[:n]...)
+			data = data[n:]
+			if len(parser.header) < 2 || len(parser.header) < wsHeaderLength(parser.header[1]) {
+				continue
+			}
+			parser.start()
+		} else {
+			n := parser.remaining
+			if n > int64(len(data)) {
+				n = int64(len(data))
+			}
+			offset := parser.size - parser.remaining
+			for i, b := range data[:n] {
+				if len(parser.payload) >= maxPayload {
+					break
+				}
+				if parser.masked {
+					b ^= parser.mask[(offset+int64(i))%4]
+				}
+				parser.payload = append(parser.payload, b)
+			}
+			parser.remaining -= n
+			data = data[n:]
+		}
+
+		if parser.inPayload && parser.remaining == 0 {
+			emit(parser.opcode, parser.size, parser.payload)
+			parser.inPayload = false
+			parser.header = parser.header[:0]
+			parser.payload = nil
+		}
+	}
+}
+
+// parses a complete frame header
+func (parser *wsFrameParser) start() {
+	header := parser.header
+	parser.opcode = header[0] & 0x0f
+	parser.masked = header[1]&0x80 != 0
+	length, next := int64(header[1]&0x7f), 2
+	switch length {
+	case 126:
+		length, next = int64(binary.BigEndian.Uint16(header[2:4])), 4
+	case 127:
+		length, next = int64(binary.BigEndian.Uint64(header[2:10])&(1<<63-1)), 10
+	}
+	if parser.masked {
+		copy(parser.mask[:], header[next:next+4])
+	}
+	parser.size = length
+	parser.remaining = length
+	parser.inPayload = true
+}
+
+// returns the length of a frame header given its second byte
+func wsHeaderLength(b byte) int {
+	length := 2
+	switch b & 0x7f {
+	case 126:
+		length += 2
+	case 127:
+		length += 8
+	}
+	if b&0x80 != 0 {
+		length += 4
+	}
+	return length
+}
+
+func wsOpcodeName(opcode byte) string {
+	switch opcode {
+	case 0x0:
+		return "continuation"
+	case 0x1:
+		return "text"
+	case 0x2:
+		return "binary"
+	case 0x8:
+		return "close"
+	case 0x9:
+		return "ping"
+	case 0xa:
+		return "pong"
+	default:
+		return "opcode_" + strconv.Itoa(int(opcode))
+	}
+}
+
+// sseEventParser splits a Server-Sent Events stream into events, separated by blank lines,
+// keeping no more than a limited number of bytes per event.
+type sseEventParser struct {
+	payload  []byte
+	size     int64
+	newlines int
+}
+
+func (parser *sseEventParser) feed(data []byte, maxPayload int, emit func(size int64, payload []byte)) {
+	for _, b := range data {
+		switch b {
+		case '\r':
+			continue
+		case '\n':
+			parser.newlines++
+			if parser.newlines == 2 {
+				if parser.size > 0 {
+					emit(parser.size-1, bytes.TrimSuffix(parser.payload, []byte("\n")))
+				}
+				parser.payload = nil
+				parser.size = 0
+				parser.newlines = 0
+				continue
+			}
+		default:
+			parser.newlines = 0
+		}
+		parser.size++
+		if len(parser.payload) < maxPayload {
+			parser.payload = append(parser.payload, b)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"net/http"
+	"strings"
+)
+
+// adds trace_id and span_id details identifying the distributed trace of req, taken from W3C Trace Context or B3
+// propagation headers. The otellogger package takes them from OpenTelemetry spans instead.
+func appendTraceDetails(message *HttpMessage, req *http.Request) {
+	traceId, spanId := "", ""
+	if traceparent := req.Header.Get("traceparent"); traceparent != "" {
+		traceId, spanId = parseTraceparent(traceparent)
+	} else if b3 := req.Header.Get("b3"); b3 != "" {
+		traceId, spanId = parseB3(b3)
+	} else if b3TraceId := req.Header.Get("X-B3-TraceId"); b3TraceId != "" {
+		traceId, spanId = parseB3(b3TraceId + "-" + req.Header.Get("X-B3-SpanId"))
+	}
+
+	if traceId == "" {
+		return
+	}
+	message.Add("trace_id", traceId)
+	message.Add("span_id", spanId)
+	if tracestate := req.Header.Get("tracestate"); tracestate != "" {
+		message.Add("trace_state", tracestate)
+	}
+}
+
+// returns the trace and parent span IDs of a W3C traceparent header, or empty strings if it isn't valid
+func parseTraceparent(traceparent string) (string, string) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", ""
+	}
+	if !isTraceHex(parts[0]) || !isTraceHex(parts[3]) || len(parts[3]) != 2 {
+		return "", ""
+	}
+	return validTraceIds(parts[1], parts[2])
+}
+
+// returns the trace and span IDs of a single b3 header, or of multiple B3 headers joined as such; or empty strings if
+// they aren't valid. 64-bit trace IDs are left-padded with zeros, as when converted to W3C Trace Context.
+func parseB3(b3 string) (string, string) {
+	parts := strings.Split(strings.TrimSpace(b3), "-")
+	if len(parts) < 2 {
+		return "", ""
+	}
+	traceId := strings.ToLower(parts[0])
+	if len(traceId) == 16 {
+		traceId = "0000000000000000" + traceId
+	}
+	return validTraceIds(traceId, strings.ToLower(parts[1]))
+}
+
+// returns trace and span IDs if they have the expected length, are lowercase hex and aren't all zeros
+func validTraceIds(traceId string, spanId string) (string, string) {
+	if len(traceId) != 32 || len(spanId) != 16 || !isTraceHex(traceId) || !isTraceHex(spanId) {
+		return "", ""
+	}
+	if strings.Trim(traceId, "0") == "" || strings.Trim(spanId, "0") == "" {
+		return "", ""
+	}
+	return traceId, spanId
+}
+
+func isTraceHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !((s[i] >= '0' && s[i] <= '9') || (s[i] >= 'a' && s[i] <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsesTraceHeaders(t *testing.T) {
+	traceId, spanId := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceId)
+	assert.Equal(t, "00f067aa0ba902b7", spanId)
+	traceId, _ = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceId)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		traceId, spanId = parseTraceparent(invalid)
+		assert.Equal(t, "", traceId, invalid)
+		assert.Equal(t, "", spanId, invalid)
+	}
+
+	traceId, spanId = parseB3("80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90")
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", traceId)
+	assert.Equal(t, "e457b5a2e4d86bd1", spanId)
+	traceId, _ = parseB3("64FE8B2A57D3EFF7-e457b5a2e4d86bd1")
+	assert.Equal(t, "000000000000000064fe8b2a57d3eff7", traceId)
+	traceId, _ = parseB3("0")
+	assert.Equal(t, "", traceId)
+}
+
+func TestAppendsTraceDetails(t *testing.T) {
+	req := MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+	message := NewHttpMessage()
+	appendTraceDetails(message, &req)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", message.Get("trace_id"))
+	assert.Equal(t, "00f067aa0ba902b7", message.Get("span_id"))
+	assert.Equal(t, "congo=t61rcWkgMzE", message.Get("trace_state"))
+
+	req = MockGetRequestWithBody([]byte("{}"), "application/json")
+	req.Header.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	req.Header.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	message = NewHttpMessage()
+	appendTraceDetails(message, &req)
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", message.Get("trace_id"))
+	assert.Equal(t, "e457b5a2e4d86bd1", message.Get("span_id"))
+
+	req = MockGetRequestWithBody([]byte("{}"), "application/json")
+	message = NewHttpMessage()
+	appendTraceDetails(message, &req)
+	assert.False(t, message.Has("trace_id"))
+}
@@ -0,0 +1,146 @@
+// © 2016-2024 Graylog, Inc.
+
+package logger
+
+import (
+	"net/url"
+	"strings"
+)
+
+// urlQueryParam is a parameter in the query string of request_url, passed through rules as a request_param detail
+type urlQueryParam struct {
+	part   string
+	key    string
+	value  string
+	detail []string
+}
+
+// urlQuery holds the parameters of request_url while rules are applied, so they are masked like request_param details
+type urlQuery []*urlQueryParam
+
+// returns the parameters in the query string of request_url, with request_param details holding their decoded values
+func parseUrlQuery(details [][]string) urlQuery {
+	var query urlQuery
+	for _, d := range details {
+		if d[0] != "request_url" {
+			continue
+		}
+		raw, found := splitUrlQuery(d[1])
+		if !found {
+			break
+		}
+		for _, part := range strings.Split(raw, "&") {
+			key, value := part, ""
+			if i := strings.Index(part, "="); i >= 0 {
+				key, value = part[:i], part[i+1:]
+			}
+			name, err := url.QueryUnescape(key)
+			if err != nil || name == "" {
+				continue
+			}
+			decoded, err := url.QueryUnescape(value)
+			if err != nil {
+				continue
+			}
+			query = append(query, &urlQueryParam{
+				part:   part,
+				key:    key,
+				value:  decoded,
+				detail: []string{"request_param:" + strings.ToLower(name), decoded},
+			})
+		}
+		break
+	}
+	return query
+}
+
+// returns the request_param details of the parameters
+func (query urlQuery) details() [][]string {
+	details := make([][]string, len(query))
+	for i, p := range query {
+		details[i] = p.detail
+	}
+	return details
+}
+
+// returns details without those of the parameters
+func (query urlQuery) without(details [][]string) [][]string {
+	if len(query) == 0 {
+		return details
+	}
+	params := make(map[*string]bool, len(query))
+	for _, p := range query {
+		params[&p.detail[0]] = true
+	}
+	var result [][]string
+	for _, d := range details {
+		if !params[&d[0]] {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// rewrites the query string of request_url with the values of its parameters as masked by rules, leaving out parameters
+// that were removed or emptied, and returns details without those of the parameters. Parts of the query string that
+// were already changed by rules on request_url are kept as they are.
+func (query urlQuery) mask(details [][]string) [][]string {
+	if len(query) == 0 {
+		return details
+	}
+	kept := make(map[*string]bool, len(details))
+	for _, d := range details {
+		kept[&d[0]] = true
+	}
+	pending := make(map[string][]*urlQueryParam, len(query))
+	for _, p := range query {
+		pending[p.part] = append(pending[p.part], p)
+	}
+
+	for _, d := range details {
+		if d[0] != "request_url" {
+			continue
+		}
+		raw, found := splitUrlQuery(d[1])
+		if !found {
+			break
+		}
+		var parts []string
+		for _, part := range strings.Split(raw, "&") {
+			if queue := pending[part]; len(queue) > 0 {
+				p := queue[0]
+				pending[part] = queue[1:]
+				switch {
+				case !kept[&p.detail[0]] || (p.detail[1] == "" && p.value != ""):
+					continue
+				case p.detail[1] != p.value:
+					part = p.key + "=" + url.QueryEscape(p.detail[1])
+				}
+			}
+			parts = append(parts, part)
+		}
+
+		start := strings.Index(d[1], "?")
+		end := start + 1 + len(raw)
+		if len(parts) == 0 {
+			d[1] = d[1][:start] + d[1][end:]
+		} else {
+			d[1] = d[1][:start+1] + strings.Join(parts, "&") + d[1][end:]
+		}
+		break
+	}
+	return query.without(details)
+}
+
+// returns the query string of a URL, without any fragment, and whether the URL has one
+func splitUrlQuery(u string) (string, bool) {
+	i := strings.Index(u, "?")
+	if i < 0 {
+		return "", false
+	}
+	raw := u[i+1:]
+	if j := strings.Index(raw, "#"); j >= 0 {
+		raw = raw[:j]
+	}
+	return raw, true
+}